@@ -7,8 +7,8 @@ require (
 	github.com/grpc-ecosystem/grpc-gateway v1.14.6
 	github.com/pkg/errors v0.9.1
 	go.mongodb.org/mongo-driver v1.3.4
+	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 	google.golang.org/genproto v0.0.0-20200513103714-09dca8ec2884
 	google.golang.org/grpc v1.30.0
-	github.com/pkg/errors v0.8.1
 	google.golang.org/protobuf v1.21.0
 )
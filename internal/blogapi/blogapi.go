@@ -0,0 +1,11 @@
+// Package blogapi holds the names of the blog API which are
+// shared by its gRPC server and the gateway.
+package blogapi
+
+// ServiceName is the name under which the health of
+// BlogService is reported by the health service.
+const ServiceName = "blog.BlogService"
+
+// ImportModeHeader is the metadata key which selects the mode of
+// ImportBlogs: "create" (the default) or "upsert".
+const ImportModeHeader = "import-mode"
@@ -0,0 +1,45 @@
+// Package etag converts blog versions to and from
+// the entity tags used by HTTP clients of the gateway.
+package etag
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Header is the gRPC metadata key under which the gateway
+// forwards the If-Match header of a request. gRPC clients may
+// set it themselves, with the same meaning.
+const Header = "if-match"
+
+// ErrInvalid is returned when an entity tag cannot be parsed.
+var ErrInvalid = errors.New("Invalid entity tag")
+
+// Format returns the strong entity tag for a blog version, e.g. "3".
+func Format(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// Parse returns the blog version of an If-Match header value.
+// The wildcard "*" matches any version and returns 0. Weak
+// tags are accepted, since versions are compared exactly.
+func Parse(tag string) (int64, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "*" {
+		return 0, nil
+	}
+
+	tag = strings.TrimPrefix(tag, "W/")
+	s, err := strconv.Unquote(tag)
+	if err != nil {
+		return 0, ErrInvalid
+	}
+
+	version, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, ErrInvalid
+	}
+	return version, nil
+}
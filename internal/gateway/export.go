@@ -0,0 +1,249 @@
+package gateway
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/utilities"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// exportPath is the gateway path of ExportBlogs, which is served
+// by exportHandler rather than the generated handlers so that the
+// blogs can be written as a file instead of a stream of results.
+const exportPath = "/api/v1/blogs:export"
+
+// The format blogs are exported in if the request does not say
+const defaultExportFormat = "ndjson"
+
+// How many blogs are written between flushes of the response
+const exportFlushInterval = 100
+
+// The query parameters which are not fields of ExportBlogsRequest
+var exportParams = utilities.NewDoubleArray([][]string{{"format"}})
+
+// exportFormat is a file format which blogs can be exported in.
+type exportFormat struct {
+	contentType string
+	extension   string
+	newWriter   func(w io.Writer) exportWriter
+}
+
+// exportFormats are the formats which blogs can be exported in,
+// keyed by the value of the format query parameter.
+var exportFormats = map[string]*exportFormat{
+	"ndjson": {"application/x-ndjson", "ndjson", newNDJSONWriter},
+	"json":   {"application/json", "json", newJSONWriter},
+	"csv":    {"text/csv", "csv", newCSVWriter},
+}
+
+// exportWriter writes exported blogs in a file format.
+type exportWriter interface {
+	write(blog *blogpb.Blog) error
+	// Ends the file after the last blog
+	close() error
+}
+
+// blogJSON is how a blog is written in the JSON formats,
+// matching the field names of the other gateway responses.
+var blogJSON = protojson.MarshalOptions{UseProtoNames: true}
+
+// exportHandler serves ExportBlogs as a file download, streaming
+// the blogs from the server as they arrive.
+func exportHandler(mux *runtime.ServeMux, client blogpb.BlogServiceClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		_, marshaler := runtime.MarshalerForRequest(mux, r)
+		fail := func(err error) {
+			runtime.HTTPError(ctx, mux, marshaler, w, r, err)
+		}
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			runtime.OtherErrorHandler(w, r, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		name := query.Get("format")
+		if name == "" {
+			name = defaultExportFormat
+		}
+		format, ok := exportFormats[name]
+		if !ok {
+			fail(status.Errorf(codes.InvalidArgument, "Unknown export format %q", name))
+			return
+		}
+
+		req := &blogpb.ExportBlogsRequest{}
+		if err := runtime.PopulateQueryParameters(req, query, exportParams); err != nil {
+			fail(status.Error(codes.InvalidArgument, err.Error()))
+			return
+		}
+
+		stream, err := client.ExportBlogs(ctx, req)
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		// Errors such as an invalid filter arrive before the first
+		// blog, so wait for it before committing to a response
+		blog, err := stream.Recv()
+		if err != nil && err != io.EOF {
+			fail(err)
+			return
+		}
+
+		filename := fmt.Sprintf("blogs-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format.extension)
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		flusher, _ := w.(http.Flusher)
+		out := format.newWriter(w)
+		for n := 1; err == nil; n++ {
+			if err = out.write(blog); err != nil {
+				break
+			}
+			if flusher != nil && n%exportFlushInterval == 0 {
+				flusher.Flush()
+			}
+			blog, err = stream.Recv()
+		}
+		if err == io.EOF {
+			err = out.close()
+		}
+		if err != nil {
+			// The status has been sent, so the response is aborted
+			// for the client to see a broken transfer rather than
+			// keeping a truncated file
+			log.Printf("Error exporting blogs: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// ndjsonWriter writes one blog per line.
+type ndjsonWriter struct {
+	w io.Writer
+}
+
+func newNDJSONWriter(w io.Writer) exportWriter {
+	return &ndjsonWriter{w: w}
+}
+
+func (e *ndjsonWriter) write(blog *blogpb.Blog) error {
+	b, err := blogJSON.Marshal(blog)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+func (e *ndjsonWriter) close() error {
+	return nil
+}
+
+// jsonWriter writes the blogs as a JSON array.
+type jsonWriter struct {
+	w io.Writer
+	// Whether any blog has been written
+	started bool
+}
+
+func newJSONWriter(w io.Writer) exportWriter {
+	return &jsonWriter{w: w}
+}
+
+func (e *jsonWriter) write(blog *blogpb.Blog) error {
+	b, err := blogJSON.Marshal(blog)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if !e.started {
+		sep = "[\n"
+		e.started = true
+	}
+	_, err = e.w.Write(append([]byte(sep), b...))
+	return err
+}
+
+func (e *jsonWriter) close() error {
+	end := "\n]\n"
+	if !e.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// The columns of a CSV export
+var csvHeader = []string{"id", "author_id", "title", "content", "version", "create_time", "update_time", "delete_time"}
+
+// csvWriter writes one blog per row, after a header row.
+type csvWriter struct {
+	w *csv.Writer
+	// Whether the header row has been written
+	started bool
+}
+
+func newCSVWriter(w io.Writer) exportWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (e *csvWriter) write(blog *blogpb.Blog) error {
+	if !e.started {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.started = true
+	}
+	err := e.w.Write([]string{
+		blog.GetId(),
+		blog.GetAuthorId(),
+		blog.GetTitle(),
+		blog.GetContent(),
+		strconv.FormatInt(blog.GetVersion(), 10),
+		formatTime(blog.GetCreateTime()),
+		formatTime(blog.GetUpdateTime()),
+		formatTime(blog.GetDeleteTime()),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Pass each row on, so that flushing the response sends it
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvWriter) close() error {
+	if !e.started {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// formatTime formats a timestamp for a CSV export,
+// returning an empty string for a nil timestamp.
+func formatTime(ts *timestamp.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return time.Unix(ts.GetSeconds(), int64(ts.GetNanos())).UTC().Format(time.RFC3339Nano)
+}
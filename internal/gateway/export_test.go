@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportClient is a BlogServiceClient whose ExportBlogs
+// returns the blogs and then the error.
+type exportClient struct {
+	blogpb.BlogServiceClient
+	blogs []*blogpb.Blog
+	err   error
+}
+
+func (c *exportClient) ExportBlogs(ctx context.Context, in *blogpb.ExportBlogsRequest, opts ...grpc.CallOption) (blogpb.BlogService_ExportBlogsClient, error) {
+	return &exportStream{blogs: c.blogs, err: c.err}, nil
+}
+
+type exportStream struct {
+	grpc.ClientStream
+	blogs []*blogpb.Blog
+	err   error
+}
+
+func (s *exportStream) Recv() (*blogpb.Blog, error) {
+	if len(s.blogs) == 0 {
+		return nil, s.err
+	}
+	blog := s.blogs[0]
+	s.blogs = s.blogs[1:]
+	return blog, nil
+}
+
+func TestExportErrors(t *testing.T) {
+	blogs := []*blogpb.Blog{
+		{Id: "5f00000000000000000000a1", AuthorId: "author", Title: "First"},
+		{Id: "5f00000000000000000000a2", AuthorId: "author", Title: "Second"},
+	}
+	var many []*blogpb.Blog
+	for i := 0; i < exportFlushInterval*2; i++ {
+		many = append(many, blogs[0])
+	}
+	shutdown := status.Error(codes.Unavailable, "Server is shutting down")
+
+	tests := []struct {
+		name   string
+		client *exportClient
+		status int
+		broken bool
+	}{
+		{"complete", &exportClient{blogs: blogs, err: io.EOF}, http.StatusOK, false},
+		{"before the first blog", &exportClient{err: shutdown}, http.StatusServiceUnavailable, false},
+		{"after the first blog", &exportClient{blogs: blogs, err: shutdown}, 0, true},
+		{"after the response is flushed", &exportClient{blogs: many, err: shutdown}, 0, true},
+	}
+
+	for _, tt := range tests {
+		for format := range exportFormats {
+			srv := httptest.NewServer(exportHandler(runtime.NewServeMux(), tt.client))
+			res, err := http.Get(srv.URL + exportPath + "?format=" + format)
+			if err == nil {
+				// An abort before the response is flushed fails the
+				// request itself, and one after fails reading the body
+				_, err = ioutil.ReadAll(res.Body)
+				res.Body.Close()
+			}
+			srv.Close()
+
+			if broken := err != nil; broken != tt.broken {
+				t.Errorf("%s (%s): Transfer broken is %t, want %t (%v)", tt.name, format, broken, tt.broken, err)
+			} else if !broken && res.StatusCode != tt.status {
+				t.Errorf("%s (%s): Got status %d, want %d", tt.name, format, res.StatusCode, tt.status)
+			}
+		}
+	}
+}
@@ -2,40 +2,164 @@ package gateway
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 
+	"github.com/dnys1/grpc-mongo/internal/blogapi"
+	"github.com/dnys1/grpc-mongo/internal/etag"
 	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	gatewayPort = flag.Int("gateway-port", 8081, "Gateway port to serve on")
 )
 
-// Run starts the gateway server on the given port,
-// connecting to the grpc server at the given endpoint.
-func Run(grpcEndpoint string) error {
-	log.Printf("Starting gateway server on port %d...", *gatewayPort)
-
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// Gateway is the HTTP reverse proxy in front of the gRPC server.
+type Gateway struct {
+	server *http.Server
+	conn   *grpc.ClientConn
+}
 
-	mux := runtime.NewServeMux()
+// New creates the gateway server, connecting to the grpc
+// server at the given endpoint.
+func New(ctx context.Context, grpcEndpoint string) (*Gateway, error) {
+	// The default error handler maps gRPC codes to HTTP statuses,
+	// e.g. NotFound to 404, InvalidArgument to 400, AlreadyExists
+	// to 409 and Unavailable to 503. A version mismatch is Aborted,
+	// which is also returned as 409, unless the request was made
+	// conditional with If-Match.
+	runtime.GlobalHTTPErrorHandler = httpError
+	mux := runtime.NewServeMux(
+		runtime.WithMetadata(ifMatch),
+		runtime.WithMetadata(importMode),
+		runtime.WithForwardResponseOption(setETag),
+	)
 	opts := []grpc.DialOption{grpc.WithInsecure()}
-	err := blogpb.RegisterBlogServiceHandlerFromEndpoint(ctx, mux, grpcEndpoint, opts)
+	conn, err := grpc.DialContext(ctx, grpcEndpoint, opts...)
 	if err != nil {
-		return fmt.Errorf("Error registering reverse proxy: %v", err)
+		return nil, fmt.Errorf("Error connecting to gRPC server: %v", err)
+	}
+
+	if err := blogpb.RegisterBlogServiceHandler(ctx, mux, conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error registering reverse proxy: %v", err)
 	}
 
-	addr := fmt.Sprintf(":%d", *gatewayPort)
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	// Exports are written as files rather than through the generated handlers
+	handler := http.NewServeMux()
+	handler.Handle(exportPath, exportHandler(mux, blogpb.NewBlogServiceClient(conn)))
+	// Counters published by the server, such as those of the read cache
+	handler.Handle("/debug/vars", expvar.Handler())
+	// Probes for orchestrators, backed by the gRPC health service
+	handler.Handle(livenessPath, livenessHandler(conn))
+	handler.Handle(readinessPath, readinessHandler(conn))
+	handler.Handle("/", mux)
+
+	return &Gateway{
+		server: &http.Server{
+			Addr:    fmt.Sprintf(":%d", *gatewayPort),
+			Handler: handler,
+		},
+		conn: conn,
+	}, nil
+}
+
+// Serve serves the gateway until it is shut down, when it returns nil.
+func (g *Gateway) Serve() error {
+	log.Printf("Starting gateway server on port %d...", *gatewayPort)
+
+	if err := g.server.ListenAndServe(); err != http.ErrServerClosed {
 		return fmt.Errorf("Failed to serve reverse proxy: %v", err)
 	}
 
 	return nil
 }
+
+// Shutdown stops the gateway from accepting requests and waits for
+// those in progress to finish. If ctx is done first, the remaining
+// requests are cut off and ctx's error is returned. The connection
+// to the gRPC server is closed either way.
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	defer g.conn.Close()
+
+	err := g.server.Shutdown(ctx)
+	if err != nil {
+		g.server.Close()
+	}
+	return err
+}
+
+// ifMatch forwards the If-Match header of a request to the server,
+// which uses it as the expected version of an update or delete.
+func ifMatch(ctx context.Context, r *http.Request) metadata.MD {
+	tag := r.Header.Get("If-Match")
+	if tag == "" {
+		return nil
+	}
+	return metadata.Pairs(etag.Header, tag)
+}
+
+// httpError writes an error like runtime.DefaultHTTPError, except
+// that a version mismatch of a request with an If-Match header is
+// returned as 412 Precondition Failed, as HTTP clients of conditional
+// requests expect.
+func httpError(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	if r.Header.Get("If-Match") != "" && status.Code(err) == codes.Aborted {
+		w = &statusWriter{ResponseWriter: w, status: http.StatusPreconditionFailed}
+	}
+	runtime.DefaultHTTPError(ctx, mux, marshaler, w, r, err)
+}
+
+// statusWriter is a ResponseWriter which replaces
+// the status of the response with its own.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader writes the header with the status of w.
+func (w *statusWriter) WriteHeader(int) {
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// importMode forwards the Import-Mode header of a request to the
+// server, which uses it to choose how ImportBlogs stores blogs.
+func importMode(ctx context.Context, r *http.Request) metadata.MD {
+	mode := r.Header.Get("Import-Mode")
+	if mode == "" {
+		return nil
+	}
+	return metadata.Pairs(blogapi.ImportModeHeader, mode)
+}
+
+// setETag sets the ETag header of responses which carry
+// the current version of a blog to the blog's version.
+func setETag(ctx context.Context, w http.ResponseWriter, resp proto.Message) error {
+	var blog *blogpb.Blog
+	switch res := resp.(type) {
+	case *blogpb.CreateBlogResponse:
+		blog = res.GetBlog()
+	case *blogpb.ReadBlogResponse:
+		blog = res.GetBlog()
+	case *blogpb.UpdateBlogResponse:
+		blog = res.GetBlog()
+	case *blogpb.RestoreBlogRevisionResponse:
+		blog = res.GetBlog()
+	case *blogpb.UndeleteBlogResponse:
+		blog = res.GetBlog()
+	}
+
+	if version := blog.GetVersion(); version > 0 {
+		w.Header().Set("ETag", etag.Format(version))
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPErrorStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		code    codes.Code
+		want    int
+	}{
+		{"mismatch with If-Match", `"3"`, codes.Aborted, http.StatusPreconditionFailed},
+		{"mismatch without If-Match", "", codes.Aborted, http.StatusConflict},
+		{"missing with If-Match", `"3"`, codes.NotFound, http.StatusNotFound},
+	}
+
+	mux := runtime.NewServeMux()
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/blogs/1", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		w := httptest.NewRecorder()
+
+		httpError(context.Background(), mux, &runtime.JSONPb{}, w, r, status.Error(tt.code, "error"))
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
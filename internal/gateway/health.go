@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/blogapi"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// The paths of the liveness and readiness probes
+const (
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+)
+
+// How long a probe waits for the health service to answer
+const probeTimeout = 2 * time.Second
+
+// livenessHandler serves the liveness probe, which succeeds as long
+// as the gRPC server answers health checks, whatever its status. A
+// server which cannot reach its database is still alive, as
+// restarting it would not bring the database back.
+func livenessHandler(conn *grpc.ClientConn) http.Handler {
+	client := healthpb.NewHealthClient(conn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+			http.Error(w, fmt.Sprintf("gRPC server is not answering: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// readinessHandler serves the readiness probe, which succeeds only
+// while BlogService is SERVING, i.e. the database can be reached and
+// the server is not shutting down.
+func readinessHandler(conn *grpc.ClientConn) http.Handler {
+	client := healthpb.NewHealthClient(conn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+
+		res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: blogapi.ServiceName})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("gRPC server is not answering: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			http.Error(w, res.GetStatus().String(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, res.GetStatus())
+	})
+}
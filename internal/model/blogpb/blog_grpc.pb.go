@@ -23,10 +23,46 @@ type BlogServiceClient interface {
 	ReadBlog(ctx context.Context, in *ReadBlogRequest, opts ...grpc.CallOption) (*ReadBlogResponse, error)
 	// Update a blog in the database
 	UpdateBlog(ctx context.Context, in *UpdateBlogRequest, opts ...grpc.CallOption) (*UpdateBlogResponse, error)
-	// Delete a blog from the database
+	// Move a blog to the trash, or delete it for good
 	DeleteBlog(ctx context.Context, in *DeleteBlogRequest, opts ...grpc.CallOption) (*DeleteBlogResponse, error)
 	// List the blogs on the server
+	//
+	// Over REST this stream used to be served at GET /api/v1/blogs,
+	// which now returns a single page from ListBlogsPage instead.
+	// Clients of the stream must move to GET /api/v1/blogs:stream.
 	ListBlogs(ctx context.Context, in *ListBlogsRequest, opts ...grpc.CallOption) (BlogService_ListBlogsClient, error)
+	// List a single page of blogs on the server
+	ListBlogsPage(ctx context.Context, in *ListBlogsRequest, opts ...grpc.CallOption) (*ListBlogsPageResponse, error)
+	// Search the title and content of the blogs on the server
+	SearchBlogs(ctx context.Context, in *SearchBlogsRequest, opts ...grpc.CallOption) (*SearchBlogsResponse, error)
+	// Create several blogs in a single call
+	BatchCreateBlogs(ctx context.Context, in *BatchCreateBlogsRequest, opts ...grpc.CallOption) (*BatchCreateBlogsResponse, error)
+	// Read several blogs in a single call
+	BatchGetBlogs(ctx context.Context, in *BatchGetBlogsRequest, opts ...grpc.CallOption) (*BatchGetBlogsResponse, error)
+	// Delete several blogs in a single call
+	BatchDeleteBlogs(ctx context.Context, in *BatchDeleteBlogsRequest, opts ...grpc.CallOption) (*BatchDeleteBlogsResponse, error)
+	// Stream the changes to blogs as they happen. Through the
+	// gateway, each event is written on its own line.
+	WatchBlogs(ctx context.Context, in *WatchBlogsRequest, opts ...grpc.CallOption) (BlogService_WatchBlogsClient, error)
+	// Export every blog matching a filter. The gateway serves
+	// this as a file download at GET /api/v1/blogs:export, taking
+	// the fields of the request as query parameters along with
+	// format=ndjson (the default), json or csv.
+	ExportBlogs(ctx context.Context, in *ExportBlogsRequest, opts ...grpc.CallOption) (BlogService_ExportBlogsClient, error)
+	// Load a stream of blogs into the database in bulk.
+	// Through the gateway, the body is a sequence of blogs
+	// as JSON objects.
+	ImportBlogs(ctx context.Context, opts ...grpc.CallOption) (BlogService_ImportBlogsClient, error)
+	// List the blogs in the trash
+	ListDeletedBlogs(ctx context.Context, in *ListDeletedBlogsRequest, opts ...grpc.CallOption) (*ListDeletedBlogsResponse, error)
+	// Restore a blog from the trash
+	UndeleteBlog(ctx context.Context, in *UndeleteBlogRequest, opts ...grpc.CallOption) (*UndeleteBlogResponse, error)
+	// List the previous versions of a blog, newest first
+	ListBlogRevisions(ctx context.Context, in *ListBlogRevisionsRequest, opts ...grpc.CallOption) (*ListBlogRevisionsResponse, error)
+	// Get a single previous version of a blog
+	GetBlogRevision(ctx context.Context, in *GetBlogRevisionRequest, opts ...grpc.CallOption) (*BlogRevision, error)
+	// Restore a blog to a previous version
+	RestoreBlogRevision(ctx context.Context, in *RestoreBlogRevisionRequest, opts ...grpc.CallOption) (*RestoreBlogRevisionResponse, error)
 }
 
 type blogServiceClient struct {
@@ -105,6 +141,194 @@ func (x *blogServiceListBlogsClient) Recv() (*ListBlogsResponse, error) {
 	return m, nil
 }
 
+func (c *blogServiceClient) ListBlogsPage(ctx context.Context, in *ListBlogsRequest, opts ...grpc.CallOption) (*ListBlogsPageResponse, error) {
+	out := new(ListBlogsPageResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/ListBlogsPage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) SearchBlogs(ctx context.Context, in *SearchBlogsRequest, opts ...grpc.CallOption) (*SearchBlogsResponse, error) {
+	out := new(SearchBlogsResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/SearchBlogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) BatchCreateBlogs(ctx context.Context, in *BatchCreateBlogsRequest, opts ...grpc.CallOption) (*BatchCreateBlogsResponse, error) {
+	out := new(BatchCreateBlogsResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/BatchCreateBlogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) BatchGetBlogs(ctx context.Context, in *BatchGetBlogsRequest, opts ...grpc.CallOption) (*BatchGetBlogsResponse, error) {
+	out := new(BatchGetBlogsResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/BatchGetBlogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) BatchDeleteBlogs(ctx context.Context, in *BatchDeleteBlogsRequest, opts ...grpc.CallOption) (*BatchDeleteBlogsResponse, error) {
+	out := new(BatchDeleteBlogsResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/BatchDeleteBlogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) WatchBlogs(ctx context.Context, in *WatchBlogsRequest, opts ...grpc.CallOption) (BlogService_WatchBlogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_BlogService_serviceDesc.Streams[1], "/blog.BlogService/WatchBlogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blogServiceWatchBlogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BlogService_WatchBlogsClient interface {
+	Recv() (*BlogEvent, error)
+	grpc.ClientStream
+}
+
+type blogServiceWatchBlogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *blogServiceWatchBlogsClient) Recv() (*BlogEvent, error) {
+	m := new(BlogEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blogServiceClient) ExportBlogs(ctx context.Context, in *ExportBlogsRequest, opts ...grpc.CallOption) (BlogService_ExportBlogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_BlogService_serviceDesc.Streams[2], "/blog.BlogService/ExportBlogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blogServiceExportBlogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BlogService_ExportBlogsClient interface {
+	Recv() (*Blog, error)
+	grpc.ClientStream
+}
+
+type blogServiceExportBlogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *blogServiceExportBlogsClient) Recv() (*Blog, error) {
+	m := new(Blog)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blogServiceClient) ImportBlogs(ctx context.Context, opts ...grpc.CallOption) (BlogService_ImportBlogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_BlogService_serviceDesc.Streams[3], "/blog.BlogService/ImportBlogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blogServiceImportBlogsClient{stream}
+	return x, nil
+}
+
+type BlogService_ImportBlogsClient interface {
+	Send(*Blog) error
+	CloseAndRecv() (*ImportSummary, error)
+	grpc.ClientStream
+}
+
+type blogServiceImportBlogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *blogServiceImportBlogsClient) Send(m *Blog) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *blogServiceImportBlogsClient) CloseAndRecv() (*ImportSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blogServiceClient) ListDeletedBlogs(ctx context.Context, in *ListDeletedBlogsRequest, opts ...grpc.CallOption) (*ListDeletedBlogsResponse, error) {
+	out := new(ListDeletedBlogsResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/ListDeletedBlogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) UndeleteBlog(ctx context.Context, in *UndeleteBlogRequest, opts ...grpc.CallOption) (*UndeleteBlogResponse, error) {
+	out := new(UndeleteBlogResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/UndeleteBlog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) ListBlogRevisions(ctx context.Context, in *ListBlogRevisionsRequest, opts ...grpc.CallOption) (*ListBlogRevisionsResponse, error) {
+	out := new(ListBlogRevisionsResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/ListBlogRevisions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) GetBlogRevision(ctx context.Context, in *GetBlogRevisionRequest, opts ...grpc.CallOption) (*BlogRevision, error) {
+	out := new(BlogRevision)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/GetBlogRevision", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blogServiceClient) RestoreBlogRevision(ctx context.Context, in *RestoreBlogRevisionRequest, opts ...grpc.CallOption) (*RestoreBlogRevisionResponse, error) {
+	out := new(RestoreBlogRevisionResponse)
+	err := c.cc.Invoke(ctx, "/blog.BlogService/RestoreBlogRevision", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // BlogServiceServer is the server API for BlogService service.
 // All implementations must embed UnimplementedBlogServiceServer
 // for forward compatibility
@@ -115,10 +339,46 @@ type BlogServiceServer interface {
 	ReadBlog(context.Context, *ReadBlogRequest) (*ReadBlogResponse, error)
 	// Update a blog in the database
 	UpdateBlog(context.Context, *UpdateBlogRequest) (*UpdateBlogResponse, error)
-	// Delete a blog from the database
+	// Move a blog to the trash, or delete it for good
 	DeleteBlog(context.Context, *DeleteBlogRequest) (*DeleteBlogResponse, error)
 	// List the blogs on the server
+	//
+	// Over REST this stream used to be served at GET /api/v1/blogs,
+	// which now returns a single page from ListBlogsPage instead.
+	// Clients of the stream must move to GET /api/v1/blogs:stream.
 	ListBlogs(*ListBlogsRequest, BlogService_ListBlogsServer) error
+	// List a single page of blogs on the server
+	ListBlogsPage(context.Context, *ListBlogsRequest) (*ListBlogsPageResponse, error)
+	// Search the title and content of the blogs on the server
+	SearchBlogs(context.Context, *SearchBlogsRequest) (*SearchBlogsResponse, error)
+	// Create several blogs in a single call
+	BatchCreateBlogs(context.Context, *BatchCreateBlogsRequest) (*BatchCreateBlogsResponse, error)
+	// Read several blogs in a single call
+	BatchGetBlogs(context.Context, *BatchGetBlogsRequest) (*BatchGetBlogsResponse, error)
+	// Delete several blogs in a single call
+	BatchDeleteBlogs(context.Context, *BatchDeleteBlogsRequest) (*BatchDeleteBlogsResponse, error)
+	// Stream the changes to blogs as they happen. Through the
+	// gateway, each event is written on its own line.
+	WatchBlogs(*WatchBlogsRequest, BlogService_WatchBlogsServer) error
+	// Export every blog matching a filter. The gateway serves
+	// this as a file download at GET /api/v1/blogs:export, taking
+	// the fields of the request as query parameters along with
+	// format=ndjson (the default), json or csv.
+	ExportBlogs(*ExportBlogsRequest, BlogService_ExportBlogsServer) error
+	// Load a stream of blogs into the database in bulk.
+	// Through the gateway, the body is a sequence of blogs
+	// as JSON objects.
+	ImportBlogs(BlogService_ImportBlogsServer) error
+	// List the blogs in the trash
+	ListDeletedBlogs(context.Context, *ListDeletedBlogsRequest) (*ListDeletedBlogsResponse, error)
+	// Restore a blog from the trash
+	UndeleteBlog(context.Context, *UndeleteBlogRequest) (*UndeleteBlogResponse, error)
+	// List the previous versions of a blog, newest first
+	ListBlogRevisions(context.Context, *ListBlogRevisionsRequest) (*ListBlogRevisionsResponse, error)
+	// Get a single previous version of a blog
+	GetBlogRevision(context.Context, *GetBlogRevisionRequest) (*BlogRevision, error)
+	// Restore a blog to a previous version
+	RestoreBlogRevision(context.Context, *RestoreBlogRevisionRequest) (*RestoreBlogRevisionResponse, error)
 	mustEmbedUnimplementedBlogServiceServer()
 }
 
@@ -141,6 +401,45 @@ func (*UnimplementedBlogServiceServer) DeleteBlog(context.Context, *DeleteBlogRe
 func (*UnimplementedBlogServiceServer) ListBlogs(*ListBlogsRequest, BlogService_ListBlogsServer) error {
 	return status.Errorf(codes.Unimplemented, "method ListBlogs not implemented")
 }
+func (*UnimplementedBlogServiceServer) ListBlogsPage(context.Context, *ListBlogsRequest) (*ListBlogsPageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBlogsPage not implemented")
+}
+func (*UnimplementedBlogServiceServer) SearchBlogs(context.Context, *SearchBlogsRequest) (*SearchBlogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchBlogs not implemented")
+}
+func (*UnimplementedBlogServiceServer) BatchCreateBlogs(context.Context, *BatchCreateBlogsRequest) (*BatchCreateBlogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchCreateBlogs not implemented")
+}
+func (*UnimplementedBlogServiceServer) BatchGetBlogs(context.Context, *BatchGetBlogsRequest) (*BatchGetBlogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetBlogs not implemented")
+}
+func (*UnimplementedBlogServiceServer) BatchDeleteBlogs(context.Context, *BatchDeleteBlogsRequest) (*BatchDeleteBlogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchDeleteBlogs not implemented")
+}
+func (*UnimplementedBlogServiceServer) WatchBlogs(*WatchBlogsRequest, BlogService_WatchBlogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchBlogs not implemented")
+}
+func (*UnimplementedBlogServiceServer) ExportBlogs(*ExportBlogsRequest, BlogService_ExportBlogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportBlogs not implemented")
+}
+func (*UnimplementedBlogServiceServer) ImportBlogs(BlogService_ImportBlogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportBlogs not implemented")
+}
+func (*UnimplementedBlogServiceServer) ListDeletedBlogs(context.Context, *ListDeletedBlogsRequest) (*ListDeletedBlogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeletedBlogs not implemented")
+}
+func (*UnimplementedBlogServiceServer) UndeleteBlog(context.Context, *UndeleteBlogRequest) (*UndeleteBlogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UndeleteBlog not implemented")
+}
+func (*UnimplementedBlogServiceServer) ListBlogRevisions(context.Context, *ListBlogRevisionsRequest) (*ListBlogRevisionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBlogRevisions not implemented")
+}
+func (*UnimplementedBlogServiceServer) GetBlogRevision(context.Context, *GetBlogRevisionRequest) (*BlogRevision, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlogRevision not implemented")
+}
+func (*UnimplementedBlogServiceServer) RestoreBlogRevision(context.Context, *RestoreBlogRevisionRequest) (*RestoreBlogRevisionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreBlogRevision not implemented")
+}
 func (*UnimplementedBlogServiceServer) mustEmbedUnimplementedBlogServiceServer() {}
 
 func RegisterBlogServiceServer(s *grpc.Server, srv BlogServiceServer) {
@@ -240,6 +539,254 @@ func (x *blogServiceListBlogsServer) Send(m *ListBlogsResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _BlogService_ListBlogsPage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBlogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).ListBlogsPage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/ListBlogsPage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).ListBlogsPage(ctx, req.(*ListBlogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_SearchBlogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchBlogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).SearchBlogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/SearchBlogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).SearchBlogs(ctx, req.(*SearchBlogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_BatchCreateBlogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCreateBlogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).BatchCreateBlogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/BatchCreateBlogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).BatchCreateBlogs(ctx, req.(*BatchCreateBlogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_BatchGetBlogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetBlogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).BatchGetBlogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/BatchGetBlogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).BatchGetBlogs(ctx, req.(*BatchGetBlogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_BatchDeleteBlogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchDeleteBlogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).BatchDeleteBlogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/BatchDeleteBlogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).BatchDeleteBlogs(ctx, req.(*BatchDeleteBlogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_WatchBlogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchBlogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlogServiceServer).WatchBlogs(m, &blogServiceWatchBlogsServer{stream})
+}
+
+type BlogService_WatchBlogsServer interface {
+	Send(*BlogEvent) error
+	grpc.ServerStream
+}
+
+type blogServiceWatchBlogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *blogServiceWatchBlogsServer) Send(m *BlogEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _BlogService_ExportBlogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportBlogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlogServiceServer).ExportBlogs(m, &blogServiceExportBlogsServer{stream})
+}
+
+type BlogService_ExportBlogsServer interface {
+	Send(*Blog) error
+	grpc.ServerStream
+}
+
+type blogServiceExportBlogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *blogServiceExportBlogsServer) Send(m *Blog) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _BlogService_ImportBlogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BlogServiceServer).ImportBlogs(&blogServiceImportBlogsServer{stream})
+}
+
+type BlogService_ImportBlogsServer interface {
+	SendAndClose(*ImportSummary) error
+	Recv() (*Blog, error)
+	grpc.ServerStream
+}
+
+type blogServiceImportBlogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *blogServiceImportBlogsServer) SendAndClose(m *ImportSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *blogServiceImportBlogsServer) Recv() (*Blog, error) {
+	m := new(Blog)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _BlogService_ListDeletedBlogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDeletedBlogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).ListDeletedBlogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/ListDeletedBlogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).ListDeletedBlogs(ctx, req.(*ListDeletedBlogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_UndeleteBlog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UndeleteBlogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).UndeleteBlog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/UndeleteBlog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).UndeleteBlog(ctx, req.(*UndeleteBlogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_ListBlogRevisions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBlogRevisionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).ListBlogRevisions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/ListBlogRevisions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).ListBlogRevisions(ctx, req.(*ListBlogRevisionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_GetBlogRevision_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBlogRevisionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).GetBlogRevision(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/GetBlogRevision",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).GetBlogRevision(ctx, req.(*GetBlogRevisionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlogService_RestoreBlogRevision_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreBlogRevisionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlogServiceServer).RestoreBlogRevision(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/blog.BlogService/RestoreBlogRevision",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlogServiceServer).RestoreBlogRevision(ctx, req.(*RestoreBlogRevisionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _BlogService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "blog.BlogService",
 	HandlerType: (*BlogServiceServer)(nil),
@@ -260,6 +807,46 @@ var _BlogService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteBlog",
 			Handler:    _BlogService_DeleteBlog_Handler,
 		},
+		{
+			MethodName: "ListBlogsPage",
+			Handler:    _BlogService_ListBlogsPage_Handler,
+		},
+		{
+			MethodName: "SearchBlogs",
+			Handler:    _BlogService_SearchBlogs_Handler,
+		},
+		{
+			MethodName: "BatchCreateBlogs",
+			Handler:    _BlogService_BatchCreateBlogs_Handler,
+		},
+		{
+			MethodName: "BatchGetBlogs",
+			Handler:    _BlogService_BatchGetBlogs_Handler,
+		},
+		{
+			MethodName: "BatchDeleteBlogs",
+			Handler:    _BlogService_BatchDeleteBlogs_Handler,
+		},
+		{
+			MethodName: "ListDeletedBlogs",
+			Handler:    _BlogService_ListDeletedBlogs_Handler,
+		},
+		{
+			MethodName: "UndeleteBlog",
+			Handler:    _BlogService_UndeleteBlog_Handler,
+		},
+		{
+			MethodName: "ListBlogRevisions",
+			Handler:    _BlogService_ListBlogRevisions_Handler,
+		},
+		{
+			MethodName: "GetBlogRevision",
+			Handler:    _BlogService_GetBlogRevision_Handler,
+		},
+		{
+			MethodName: "RestoreBlogRevision",
+			Handler:    _BlogService_RestoreBlogRevision_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -267,6 +854,21 @@ var _BlogService_serviceDesc = grpc.ServiceDesc{
 			Handler:       _BlogService_ListBlogs_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "WatchBlogs",
+			Handler:       _BlogService_WatchBlogs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportBlogs",
+			Handler:       _BlogService_ExportBlogs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportBlogs",
+			Handler:       _BlogService_ImportBlogs_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "blog.proto",
 }
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/validation"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+// BatchCreateBlogs creates several blogs. Unless the request is
+// all-or-nothing, invalid blogs fail on their own and the rest
+// are still created.
+func (s *Server) BatchCreateBlogs(ctx context.Context, req *blogpb.BatchCreateBlogsRequest) (*blogpb.BatchCreateBlogsResponse, error) {
+	items := req.GetRequests()
+	log.Printf("BatchCreateBlogs: Invoked with %d blogs (all or nothing %t)", len(items), req.GetAllOrNothing())
+
+	if err := validation.BatchCreateBlogs(req); err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	results := make([]*blogpb.BatchCreateBlogResult, len(items))
+
+	// The blogs which are valid, along with their index in the request
+	var blogs []*blogpb.Blog
+	var indexes []int
+	for i, item := range items {
+		if err := validation.CreateBlog(item); err != nil {
+			results[i] = &blogpb.BatchCreateBlogResult{Error: itemStatus(err, "Error validating blog")}
+			continue
+		}
+		blogs = append(blogs, item.GetBlog())
+		indexes = append(indexes, i)
+	}
+
+	res, err := s.db.CreateBlogs(ctx, blogs, req.GetAllOrNothing())
+	if err != nil {
+		return nil, statusError(err, "Error inserting documents")
+	}
+
+	created := 0
+	for j, r := range res {
+		results[indexes[j]] = &blogpb.BatchCreateBlogResult{
+			Blog:  r.Blog,
+			Error: itemStatus(r.Err, "Error inserting document"),
+		}
+		if r.Err == nil {
+			created++
+		}
+	}
+
+	log.Printf("BatchCreateBlogs: %d of %d blogs successfully created", created, len(items))
+
+	return &blogpb.BatchCreateBlogsResponse{
+		Results: results,
+	}, nil
+}
+
+// BatchGetBlogs reads several blogs.
+func (s *Server) BatchGetBlogs(ctx context.Context, req *blogpb.BatchGetBlogsRequest) (*blogpb.BatchGetBlogsResponse, error) {
+	ids := req.GetIds()
+	log.Printf("BatchGetBlogs: Invoked with %d ids", len(ids))
+
+	if err := validation.BatchGetBlogs(req); err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	res, err := s.db.ReadBlogs(ctx, ids)
+	if err != nil {
+		return nil, statusError(err, "Error retrieving documents")
+	}
+
+	results := make([]*blogpb.BatchGetBlogResult, len(ids))
+	found := 0
+	for i, r := range res {
+		results[i] = &blogpb.BatchGetBlogResult{
+			Id:    ids[i],
+			Blog:  r.Blog,
+			Error: itemStatus(r.Err, "Error retrieving document"),
+		}
+		if r.Err == nil {
+			found++
+		}
+	}
+
+	log.Printf("BatchGetBlogs: %d of %d blogs successfully found", found, len(ids))
+
+	return &blogpb.BatchGetBlogsResponse{
+		Results: results,
+	}, nil
+}
+
+// BatchDeleteBlogs moves several blogs to the trash, or removes
+// them from the database if the request asks to purge them.
+func (s *Server) BatchDeleteBlogs(ctx context.Context, req *blogpb.BatchDeleteBlogsRequest) (*blogpb.BatchDeleteBlogsResponse, error) {
+	ids := req.GetIds()
+	log.Printf("BatchDeleteBlogs: Invoked with %d ids (purge %t, all or nothing %t)", len(ids), req.GetPurge(), req.GetAllOrNothing())
+
+	if err := validation.BatchDeleteBlogs(req); err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	res, err := s.db.DeleteBlogs(ctx, ids, req.GetPurge(), req.GetAllOrNothing())
+	if err != nil {
+		return nil, statusError(err, "Error deleting documents")
+	}
+
+	results := make([]*blogpb.BatchDeleteBlogResult, len(ids))
+	deleted := 0
+	for i, r := range res {
+		result := &blogpb.BatchDeleteBlogResult{
+			Id:     ids[i],
+			Status: blogpb.DeleteBlogResponse_DELETED,
+		}
+		if r.Err != nil {
+			result.Status = blogpb.DeleteBlogResponse_NOT_DELETED
+			result.Error = itemStatus(r.Err, "Error deleting document")
+		} else {
+			deleted++
+		}
+		results[i] = result
+	}
+
+	log.Printf("BatchDeleteBlogs: %d of %d blogs successfully deleted", deleted, len(ids))
+
+	return &blogpb.BatchDeleteBlogsResponse{
+		Results: results,
+	}, nil
+}
+
+// itemStatus returns the status reported for a failed item of a
+// batch, or nil if err is nil.
+func itemStatus(err error, msg string) *spb.Status {
+	if err == nil {
+		return nil
+	}
+	return newStatus(err, msg).Proto()
+}
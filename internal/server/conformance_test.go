@@ -0,0 +1,247 @@
+package server_test
+
+// The conformance tests check that the server implements the
+// documented status semantics of the unary BlogService RPCs.
+//
+// Every successful call returns OK along with the success value of
+// its status enum (FOUND, UPDATED or DELETED). A call which fails
+// with NOT_FOUND (missing blog), INVALID_ARGUMENT (malformed ID) or
+// ABORTED (version mismatch) carries the response message with the
+// failure value of the enum (NOT_FOUND, NOT_UPDATED or NOT_DELETED)
+// in the error details. Any other failure carries no details, which
+// clients read as the UNKNOWN status.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/database/memory"
+	mongodb "github.com/dnys1/grpc-mongo/internal/server/database/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// mongoURIEnv names the environment variable holding the URI of a
+// MongoDB deployment to run the tests against. The mongo backend is
+// skipped when it is unset.
+const mongoURIEnv = "TEST_MONGO_URI"
+
+// An ID which is well-formed but never assigned to a blog
+const missingID = "000000000000000000000000"
+
+// A malformed ID
+const invalidID = "not-an-id"
+
+// testCase is a single call and its expected outcome.
+type testCase struct {
+	name string
+	// Makes the call, returning the status enum of a successful
+	// response or of the response in the error details.
+	call func(ctx context.Context) (string, error)
+	// The expected gRPC code
+	code codes.Code
+	// The expected status enum, or "" if none is expected
+	status string
+}
+
+func TestConformanceMemory(t *testing.T) {
+	testConformance(t, memory.New())
+}
+
+func TestConformanceMongo(t *testing.T) {
+	uri := os.Getenv(mongoURIEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", mongoURIEnv)
+	}
+
+	db, err := mongodb.New(&mongodb.MongoDatabaseOptions{
+		URI:      uri,
+		Database: fmt.Sprintf("conformance_%d", time.Now().UnixNano()),
+		Indexes:  mongodb.IndexOptions{Ensure: true},
+	})
+	if err != nil {
+		t.Fatalf("Error creating database: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.Connect(ctx); err != nil {
+		t.Fatalf("Error connecting to database: %v", err)
+	}
+	defer func() {
+		if err := db.Collection().Database().Drop(ctx); err != nil {
+			t.Errorf("Error dropping database: %v", err)
+		}
+		if err := db.Disconnect(ctx); err != nil {
+			t.Errorf("Error disconnecting from database: %v", err)
+		}
+	}()
+
+	testConformance(t, db)
+}
+
+// testConformance runs the conformance checks against a server
+// backed by db, which it reaches over an in-memory connection.
+func testConformance(t *testing.T, db database.Database) {
+	c := dial(t, db)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := c.CreateBlog(ctx, &blogpb.CreateBlogRequest{
+		Blog: &blogpb.Blog{
+			AuthorId: "conformance",
+			Title:    "Conformance",
+			Content:  "Checking status semantics",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error creating blog: %v", err)
+	}
+	id := res.GetBlog().GetId()
+
+	for _, tc := range cases(c, id) {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := tc.call(ctx)
+			if code := status.Code(err); code != tc.code || st != tc.status {
+				t.Errorf("got %s/%q, want %s/%q (%v)", code, st, tc.code, tc.status, err)
+			}
+		})
+	}
+}
+
+// dial starts a server backed by db on an in-memory listener,
+// returning a client connected to it. Both are stopped when the
+// test finishes.
+func dial(t *testing.T, db database.Database) blogpb.BlogServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	blogpb.RegisterBlogServiceServer(s, server.NewServer(db, nil))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("Could not connect to gRPC server: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	return blogpb.NewBlogServiceClient(cc)
+}
+
+// cases returns the conformance checks, in order, for the blog with
+// the given ID. The checks delete the blog.
+func cases(c blogpb.BlogServiceClient, id string) []testCase {
+	create := func(blog *blogpb.Blog) func(context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			_, err := c.CreateBlog(ctx, &blogpb.CreateBlogRequest{Blog: blog})
+			return "", err
+		}
+	}
+	read := func(id string) func(context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			res, err := c.ReadBlog(ctx, &blogpb.ReadBlogRequest{Id: id})
+			for _, d := range details(err) {
+				if r, ok := d.(*blogpb.ReadBlogResponse); ok {
+					res = r
+				}
+			}
+			return enum(res != nil, res.GetStatus().String()), err
+		}
+	}
+	update := func(id string, version int64) func(context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			res, err := c.UpdateBlog(ctx, &blogpb.UpdateBlogRequest{
+				Blog: &blogpb.Blog{
+					Id:       id,
+					AuthorId: "conformance",
+					Title:    "Conformance (edited)",
+					Content:  "Checking status semantics",
+				},
+				ExpectedVersion: version,
+			})
+			for _, d := range details(err) {
+				if r, ok := d.(*blogpb.UpdateBlogResponse); ok {
+					res = r
+				}
+			}
+			return enum(res != nil, res.GetStatus().String()), err
+		}
+	}
+	del := func(id string, version int64) func(context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			res, err := c.DeleteBlog(ctx, &blogpb.DeleteBlogRequest{Id: id, ExpectedVersion: version})
+			for _, d := range details(err) {
+				if r, ok := d.(*blogpb.DeleteBlogResponse); ok {
+					res = r
+				}
+			}
+			return enum(res != nil, res.GetStatus().String()), err
+		}
+	}
+	batchDel := func(ids []string, purge, allOrNothing bool) func(context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			res, err := c.BatchDeleteBlogs(ctx, &blogpb.BatchDeleteBlogsRequest{
+				Ids:          ids,
+				Purge:        purge,
+				AllOrNothing: allOrNothing,
+			})
+			var statuses []string
+			for _, r := range res.GetResults() {
+				statuses = append(statuses, r.GetStatus().String())
+			}
+			return strings.Join(statuses, ","), err
+		}
+	}
+
+	return []testCase{
+		{"CreateBlog empty", create(&blogpb.Blog{}), codes.InvalidArgument, ""},
+		{"CreateBlog with id", create(&blogpb.Blog{Id: id, AuthorId: "conformance", Title: "Conformance"}), codes.InvalidArgument, ""},
+		{"ReadBlog existing", read(id), codes.OK, "FOUND"},
+		{"ReadBlog missing", read(missingID), codes.NotFound, "NOT_FOUND"},
+		{"ReadBlog malformed", read(invalidID), codes.InvalidArgument, "NOT_FOUND"},
+		{"UpdateBlog existing", update(id, 1), codes.OK, "UPDATED"},
+		{"UpdateBlog stale version", update(id, 1), codes.Aborted, "NOT_UPDATED"},
+		{"UpdateBlog missing", update(missingID, 0), codes.NotFound, "NOT_UPDATED"},
+		{"UpdateBlog malformed", update(invalidID, 0), codes.InvalidArgument, "NOT_UPDATED"},
+		{"BatchDeleteBlogs duplicate all or nothing", batchDel([]string{id, id}, false, true), codes.NotFound, ""},
+		{"DeleteBlog stale version", del(id, 1), codes.Aborted, "NOT_DELETED"},
+		{"DeleteBlog existing", del(id, 2), codes.OK, "DELETED"},
+		{"DeleteBlog deleted", del(id, 0), codes.NotFound, "NOT_DELETED"},
+		{"DeleteBlog malformed", del(invalidID, 0), codes.InvalidArgument, "NOT_DELETED"},
+		{"ReadBlog deleted", read(id), codes.NotFound, "NOT_FOUND"},
+		{"BatchDeleteBlogs duplicate", batchDel([]string{id, id}, true, false), codes.OK, "DELETED,NOT_DELETED"},
+	}
+}
+
+// details returns the messages in the details of err, if any.
+func details(err error) []interface{} {
+	if err == nil {
+		return nil
+	}
+	return status.Convert(err).Details()
+}
+
+// enum returns the name of a status enum, or "" if there was no response.
+func enum(ok bool, name string) string {
+	if !ok {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+)
+
+// BatchResult is the outcome of a single item of a batch
+// operation. Results are returned in the order of the items.
+type BatchResult struct {
+	// The blog which was created or read, if any
+	Blog *blogpb.Blog
+	// Why the item failed, or nil if it succeeded
+	Err error
+}
+
+// ItemError is returned by an all-or-nothing batch
+// operation when one of its items fails.
+type ItemError struct {
+	// The index of the first failing item
+	Index int
+	// Why the item failed
+	Err error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("Item %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error of the failing item,
+// so that it can be compared with errors.Is.
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// UpsertResult is the outcome of a single blog of UpsertBlogs.
+type UpsertResult struct {
+	// The blog as stored, if it was created or updated
+	Blog *blogpb.Blog
+	// Whether the blog was created rather than updated
+	Created bool
+	// Whether the stored blog already matched, so it was left alone
+	Unchanged bool
+	// Why the blog could not be stored, or nil if it succeeded
+	Err error
+}
+
+// SameFields reports whether a stored blog already has
+// the updatable fields of blog.
+func SameFields(stored, blog *blogpb.Blog) bool {
+	return stored.GetAuthorId() == blog.GetAuthorId() &&
+		stored.GetTitle() == blog.GetTitle() &&
+		stored.GetContent() == blog.GetContent()
+}
@@ -0,0 +1,248 @@
+// Package cache provides a read-through cache of blogs which
+// can be placed in front of any database.Database.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/sync/singleflight"
+)
+
+// How long a read shared by concurrent misses may take. It does not
+// use the context of any one caller, which may give up before the
+// others do.
+const sharedReadTimeout = 30 * time.Second
+
+// Options configures a CachedDatabase.
+type Options struct {
+	// The most blogs which are kept in the cache.
+	Size int
+	// How long a blog is kept in the cache after it is read.
+	TTL time.Duration
+}
+
+// Stats counts the reads served by a CachedDatabase.
+type Stats struct {
+	// Reads which were served from the cache
+	Hits uint64 `json:"hits"`
+	// Reads which went to the database
+	Misses uint64 `json:"misses"`
+	// The number of blogs in the cache
+	Size int `json:"size"`
+}
+
+// CachedDatabase is a Database which keeps recently read blogs in
+// an LRU cache, so that ReadBlog only goes to the wrapped database
+// on a miss. Concurrent misses for the same blog share a single
+// read.
+//
+// Blogs are removed from the cache when they are written through
+// the CachedDatabase. Writes made elsewhere, such as by another
+// server, are only seen once the cached blog expires.
+type CachedDatabase struct {
+	database.Database
+	opts Options
+
+	mu sync.Mutex
+	// The cached blogs, most recently used first
+	lru   *list.List
+	items map[string]*list.Element
+	// Incremented by every invalidation, so that a read which
+	// overlaps a write does not cache what it read
+	epoch uint64
+
+	reads  singleflight.Group
+	hits   uint64
+	misses uint64
+}
+
+// entry is a blog in the cache.
+type entry struct {
+	id      string
+	blog    *blogpb.Blog
+	expires time.Time
+}
+
+// New wraps db with a cache.
+func New(db database.Database, opts Options) *CachedDatabase {
+	return &CachedDatabase{
+		Database: db,
+		opts:     opts,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Stats returns the number of hits and misses so far.
+func (c *CachedDatabase) Stats() Stats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   size,
+	}
+}
+
+// ReadBlog reads a blog from the cache, or from the database
+// if it is not cached. Errors such as ErrNotFound are not cached.
+func (c *CachedDatabase) ReadBlog(ctx context.Context, id string) (*blogpb.Blog, error) {
+	if blog, ok := c.get(id); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return blog, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	// The read is shared with any other misses for the same blog, so
+	// it runs on its own context, and each caller stops waiting for
+	// it once their own context is done
+	ch := c.reads.DoChan(id, func() (interface{}, error) {
+		readCtx, cancel := context.WithTimeout(context.Background(), sharedReadTimeout)
+		defer cancel()
+
+		epoch := c.currentEpoch()
+		blog, err := c.Database.ReadBlog(readCtx, id)
+		if err != nil {
+			return nil, err
+		}
+		c.add(id, blog, epoch)
+		return blog, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return clone(res.Val.(*blogpb.Blog)), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// UpdateBlog updates a blog and removes it from the cache.
+func (c *CachedDatabase) UpdateBlog(ctx context.Context, blog *blogpb.Blog, fields []database.Field, expectedVersion int64) (*blogpb.Blog, error) {
+	defer c.invalidate(blog.GetId())
+	return c.Database.UpdateBlog(ctx, blog, fields, expectedVersion)
+}
+
+// DeleteBlog moves a blog to the trash and removes it from the cache.
+func (c *CachedDatabase) DeleteBlog(ctx context.Context, id string, expectedVersion int64) (blogpb.DeleteBlogResponse_DeleteStatus, error) {
+	defer c.invalidate(id)
+	return c.Database.DeleteBlog(ctx, id, expectedVersion)
+}
+
+// PurgeBlog removes a blog from the database and the cache.
+func (c *CachedDatabase) PurgeBlog(ctx context.Context, id string, expectedVersion int64) (blogpb.DeleteBlogResponse_DeleteStatus, error) {
+	defer c.invalidate(id)
+	return c.Database.PurgeBlog(ctx, id, expectedVersion)
+}
+
+// UndeleteBlog restores a blog from the trash and removes it from the cache.
+func (c *CachedDatabase) UndeleteBlog(ctx context.Context, id string) (*blogpb.Blog, error) {
+	defer c.invalidate(id)
+	return c.Database.UndeleteBlog(ctx, id)
+}
+
+// DeleteBlogs deletes several blogs and removes them from the cache.
+func (c *CachedDatabase) DeleteBlogs(ctx context.Context, ids []string, purge, allOrNothing bool) ([]*database.BatchResult, error) {
+	defer c.invalidate(ids...)
+	return c.Database.DeleteBlogs(ctx, ids, purge, allOrNothing)
+}
+
+// UpsertBlogs stores several blogs and removes them from the cache.
+func (c *CachedDatabase) UpsertBlogs(ctx context.Context, blogs []*blogpb.Blog) ([]*database.UpsertResult, error) {
+	ids := make([]string, len(blogs))
+	for i, blog := range blogs {
+		ids[i] = blog.GetId()
+	}
+	defer c.invalidate(ids...)
+	return c.Database.UpsertBlogs(ctx, blogs)
+}
+
+// get returns a copy of the cached blog with the given ID,
+// if it is cached and has not expired.
+func (c *CachedDatabase) get(id string) (*blogpb.Blog, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return clone(e.blog), true
+}
+
+// add caches a blog which was read at the given epoch, unless
+// it has been invalidated since, evicting the least recently
+// used blogs if the cache is full.
+func (c *CachedDatabase) add(id string, blog *blogpb.Blog, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if epoch != c.epoch || c.opts.Size <= 0 {
+		return
+	}
+
+	e := &entry{id: id, blog: clone(blog), expires: time.Now().Add(c.opts.TTL)}
+	if el, ok := c.items[id]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+	} else {
+		c.items[id] = c.lru.PushFront(e)
+	}
+
+	for c.lru.Len() > c.opts.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate removes the blogs with the given IDs from the cache.
+// Reads which are in flight are not cached, and later reads do not
+// share them.
+func (c *CachedDatabase) invalidate(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	for _, id := range ids {
+		if el, ok := c.items[id]; ok {
+			c.remove(el)
+		}
+		c.reads.Forget(id)
+	}
+}
+
+func (c *CachedDatabase) currentEpoch() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// remove removes an element from the cache. It must be called with c.mu held.
+func (c *CachedDatabase) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*entry).id)
+}
+
+// clone returns a copy of the blog which is safe
+// to hand out to callers.
+func clone(blog *blogpb.Blog) *blogpb.Blog {
+	return proto.Clone(blog).(*blogpb.Blog)
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/database/memory"
+)
+
+// slowDatabase is a Database whose reads wait until release is closed.
+type slowDatabase struct {
+	database.Database
+	release chan struct{}
+}
+
+func (db *slowDatabase) ReadBlog(ctx context.Context, id string) (*blogpb.Blog, error) {
+	select {
+	case <-db.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return db.Database.ReadBlog(ctx, id)
+}
+
+func TestSharedReadOutlivesCanceledCaller(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New()
+	blog, err := mem.CreateBlog(ctx, &blogpb.Blog{AuthorId: "author", Title: "Title"})
+	if err != nil {
+		t.Fatalf("Error creating blog: %v", err)
+	}
+
+	db := &slowDatabase{Database: mem, release: make(chan struct{})}
+	c := New(db, Options{Size: 10, TTL: time.Minute})
+
+	// The first caller starts the shared read, then gives up on it
+	firstCtx, cancelFirst := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.ReadBlog(firstCtx, blog.GetId())
+		first <- err
+	}()
+
+	second := make(chan error, 1)
+	go func() {
+		res, err := c.ReadBlog(ctx, blog.GetId())
+		if err == nil && res.GetId() != blog.GetId() {
+			t.Errorf("Read blog %q, want %q", res.GetId(), blog.GetId())
+		}
+		second <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancelFirst()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("First read returned %v, want %v", err, context.Canceled)
+	}
+
+	close(db.release)
+	if err := <-second; err != nil {
+		t.Errorf("Second read returned %v, want the blog", err)
+	}
+}
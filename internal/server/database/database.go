@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
 )
@@ -11,14 +12,165 @@ type Database interface {
 	Connect(ctx context.Context) error
 	Disconnect(ctx context.Context) error
 	Endpoint() string
-	// Creates a blog in the database
+	// Checks that the database can be reached, returning
+	// ErrUnavailable if it cannot.
+	Ping(ctx context.Context) error
+	// Creates a blog in the database at version 1
 	CreateBlog(ctx context.Context, blog *blogpb.Blog) (*blogpb.Blog, error)
-	// Reads a user from the database
+	// Reads a blog from the database, or returns ErrNotFound.
+	// Blogs in the trash are not found by any method which
+	// does not say otherwise.
 	ReadBlog(ctx context.Context, id string) (*blogpb.Blog, error)
-	// Updates a blog in the database
-	UpdateBlog(ctx context.Context, blog *blogpb.Blog) (blogpb.UpdateBlogResponse_UpdateStatus, error)
-	// Deletes a blog from the database
-	DeleteBlog(ctx context.Context, id string) (blogpb.DeleteBlogResponse_DeleteStatus, error)
-	// Lists all the blogs in the database
-	ListBlogs(stream blogpb.BlogService_ListBlogsServer) error
+	// Updates the given fields of a blog in the database, leaving the
+	// others unchanged, and returns the blog with its incremented
+	// version. The replaced version is recorded as a revision. A
+	// missing blog returns ErrNotFound. If expectedVersion is
+	// non-zero and does not match, it returns ErrVersionMismatch.
+	UpdateBlog(ctx context.Context, blog *blogpb.Blog, fields []Field, expectedVersion int64) (*blogpb.Blog, error)
+	// Moves a blog to the trash by setting its delete time. A missing
+	// blog returns NOT_DELETED along with ErrNotFound. If expectedVersion
+	// is non-zero and does not match, it returns ErrVersionMismatch.
+	DeleteBlog(ctx context.Context, id string, expectedVersion int64) (blogpb.DeleteBlogResponse_DeleteStatus, error)
+	// Removes a blog and its revisions from the database, whether
+	// or not the blog is in the trash. Otherwise it behaves like
+	// DeleteBlog.
+	PurgeBlog(ctx context.Context, id string, expectedVersion int64) (blogpb.DeleteBlogResponse_DeleteStatus, error)
+	// Restores a blog from the trash, or returns ErrNotFound
+	// if there is no such blog in the trash.
+	UndeleteBlog(ctx context.Context, id string) (*blogpb.Blog, error)
+	// Removes the blogs which were moved to the trash before
+	// the given time, along with their revisions, and returns
+	// how many blogs were removed.
+	PurgeDeletedBlogs(ctx context.Context, before time.Time) (int, error)
+	// Creates several blogs, which succeed or fail independently.
+	// If allOrNothing is set, either every blog is created or, if
+	// any fails, none are and an *ItemError is returned.
+	CreateBlogs(ctx context.Context, blogs []*blogpb.Blog, allOrNothing bool) ([]*BatchResult, error)
+	// Reads several blogs, each of which may fail like ReadBlog.
+	ReadBlogs(ctx context.Context, ids []string) ([]*BatchResult, error)
+	// Deletes several blogs like DeleteBlog or, if purge is set,
+	// PurgeBlog. If allOrNothing is set, either every blog is
+	// deleted or, if any fails, none are and an *ItemError is
+	// returned.
+	DeleteBlogs(ctx context.Context, ids []string, purge, allOrNothing bool) ([]*BatchResult, error)
+	// Stores several blogs, keeping the IDs they are given. A blog
+	// with a new ID, or none, is created at version 1, keeping any
+	// create and update times it is given. An existing blog has its
+	// updatable fields replaced like UpdateBlog, unless they already
+	// match. A blog in the trash is not replaced and fails with
+	// ErrConflict.
+	UpsertBlogs(ctx context.Context, blogs []*blogpb.Blog) ([]*UpsertResult, error)
+	// Lists the blogs in the database in the requested order, calling fn for each one.
+	// Iteration stops at the first error returned by fn or when ctx is done.
+	ListBlogs(ctx context.Context, opts *ListOptions, fn func(*blogpb.Blog) error) error
+	// Searches the title and content of the blogs in the database,
+	// returning at most limit results, most relevant first.
+	SearchBlogs(ctx context.Context, query string, limit int) ([]*SearchResult, error)
+	// Watches the changes to blogs, calling fn for each one in the
+	// order they were made. It blocks until fn returns an error or
+	// ctx is done. A malformed resume token returns
+	// ErrInvalidResumeToken, and one whose changes are no longer
+	// available returns ErrResumeTokenExpired.
+	WatchBlogs(ctx context.Context, opts *WatchOptions, fn func(*Change) error) error
+	// Lists the revisions of a blog, newest first, returning at most
+	// limit revisions (or all, if zero) with a version less than
+	// before (or any version, if zero). The revisions of a blog in
+	// the trash are hidden until it is restored, so it returns
+	// ErrDeleted, and those of a missing blog return ErrNotFound.
+	ListBlogRevisions(ctx context.Context, id string, before int64, limit int) ([]*blogpb.BlogRevision, error)
+	// Gets the revision of a blog at a version, or returns ErrNotFound.
+	// Like ListBlogRevisions, it returns ErrDeleted for a blog in the trash.
+	GetBlogRevision(ctx context.Context, id string, version int64) (*blogpb.BlogRevision, error)
+}
+
+// ListOptions specifies which blogs are returned by ListBlogs.
+// A nil *ListOptions lists every blog in the database in ID order.
+type ListOptions struct {
+	// The order in which blogs are listed.
+	Sort Sort
+	// Only list blogs which come after this position in the
+	// sort order. A nil After starts from the first blog.
+	After *Cursor
+	// The maximum number of blogs to list, or zero for no limit.
+	Limit int
+	// Restricts the blogs which are listed.
+	Filter Filter
+	// List the blogs in the trash instead of the others.
+	Deleted bool
+}
+
+// Filter restricts the blogs which are listed by ListBlogs.
+// Blogs must match every non-empty or non-zero field. IDs are expected
+// to be valid hex ObjectIDs.
+type Filter struct {
+	// Only list blogs written by this author.
+	AuthorID string
+	// Only list blogs whose title starts with this prefix (case-sensitive).
+	TitlePrefix string
+	// Only list blogs whose title contains this text (case-insensitive).
+	TitleContains string
+	// Only list blogs with an ID greater than or equal to MinID.
+	MinID string
+	// Only list blogs with an ID less than MaxID.
+	MaxID string
+	// Only list blogs created at or after MinCreateTime.
+	MinCreateTime time.Time
+	// Only list blogs created before MaxCreateTime.
+	MaxCreateTime time.Time
+	// Only list blogs updated at or after MinUpdateTime.
+	MinUpdateTime time.Time
+	// Only list blogs updated before MaxUpdateTime.
+	MaxUpdateTime time.Time
+}
+
+// SortField is a field which blogs can be sorted by.
+type SortField string
+
+// The fields which blogs can be sorted by.
+const (
+	SortByID         SortField = "id"
+	SortByAuthorID   SortField = "author_id"
+	SortByTitle      SortField = "title"
+	SortByCreateTime SortField = "create_time"
+	SortByUpdateTime SortField = "update_time"
+)
+
+// Sort is the order in which blogs are listed. Blogs with
+// equal sort fields are ordered by ID in the same direction.
+// The zero value sorts by ascending ID.
+type Sort struct {
+	Field      SortField
+	Descending bool
+}
+
+// Cursor is a position in the sort order of ListBlogs.
+type Cursor struct {
+	// The ID of the blog at this position.
+	ID string
+	// The value of the sort field of the blog at this
+	// position. It is unused when sorting by ID. Times
+	// are formatted with FormatCursorTime.
+	Value string
+}
+
+// IsTimeField reports whether a sort field holds a time.
+func IsTimeField(field SortField) bool {
+	return field == SortByCreateTime || field == SortByUpdateTime
+}
+
+// SortValue returns the value of the given sort field of blog,
+// in the form used by Cursor.Value.
+func SortValue(blog *blogpb.Blog, field SortField) string {
+	switch field {
+	case SortByAuthorID:
+		return blog.GetAuthorId()
+	case SortByTitle:
+		return blog.GetTitle()
+	case SortByCreateTime:
+		return FormatCursorTime(Time(blog.GetCreateTime()))
+	case SortByUpdateTime:
+		return FormatCursorTime(Time(blog.GetUpdateTime()))
+	default:
+		return ""
+	}
 }
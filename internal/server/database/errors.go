@@ -0,0 +1,50 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Errors returned by every Database implementation. They may be
+// wrapped with additional context, so callers should compare
+// them with errors.Is.
+var (
+	// ErrNotFound is returned when a blog does not exist.
+	ErrNotFound = errors.New("Blog not found")
+	// ErrInvalidID is returned when a blog ID is malformed.
+	ErrInvalidID = errors.New("Invalid blog ID")
+	// ErrConflict is returned when a write conflicts with an existing blog.
+	ErrConflict = errors.New("Blog already exists")
+	// ErrInTrash is returned when a write would replace a blog
+	// in the trash. It wraps ErrConflict.
+	ErrInTrash = fmt.Errorf("%w in the trash", ErrConflict)
+	// ErrDeleted is returned when the revisions of a blog
+	// in the trash are read.
+	ErrDeleted = errors.New("Blog is in the trash")
+	// ErrVersionMismatch is returned when a write expects
+	// a different version of the blog than the stored one.
+	ErrVersionMismatch = errors.New("Blog version does not match")
+	// ErrUnavailable is returned when the database cannot be reached.
+	ErrUnavailable = errors.New("Database unavailable")
+	// ErrUnsupported is returned when an operation is not
+	// supported by the database deployment.
+	ErrUnsupported = errors.New("Operation not supported by the database")
+	// ErrInvalidResumeToken is returned when a resume token is malformed.
+	ErrInvalidResumeToken = errors.New("Invalid resume token")
+	// ErrResumeTokenExpired is returned when the changes after
+	// a resume token are no longer available.
+	ErrResumeTokenExpired = errors.New("Resume token has expired")
+)
+
+// ParseID parses a blog ID, which is the hex encoding of an
+// ObjectID. It returns an error wrapping ErrInvalidID if the
+// ID is malformed.
+func ParseID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("%w %q", ErrInvalidID, id)
+	}
+	return oid, nil
+}
@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateBlogs creates several blogs. Creating a blog in memory
+// cannot fail, so the batch is always applied in full.
+func (db *MemoryDatabase) CreateBlogs(ctx context.Context, blogs []*blogpb.Blog, allOrNothing bool) ([]*database.BatchResult, error) {
+	now := database.Timestamp(database.Now())
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	results := make([]*database.BatchResult, len(blogs))
+	for i, blog := range blogs {
+		results[i] = &database.BatchResult{Blog: clone(db.create(blog, now))}
+	}
+
+	return results, nil
+}
+
+// ReadBlogs reads several blogs.
+func (db *MemoryDatabase) ReadBlogs(ctx context.Context, ids []string) ([]*database.BatchResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	results := make([]*database.BatchResult, len(ids))
+	for i, id := range ids {
+		res := &database.BatchResult{}
+		results[i] = res
+
+		oid, err := database.ParseID(id)
+		if err != nil {
+			res.Err = err
+			continue
+		}
+		data, ok := db.live(oid)
+		if !ok {
+			res.Err = database.ErrNotFound
+			continue
+		}
+		res.Blog = clone(data)
+	}
+
+	return results, nil
+}
+
+// DeleteBlogs moves several blogs to the trash or, if purge
+// is set, removes them from the database. The batch is checked
+// in full before any blog is deleted.
+func (db *MemoryDatabase) DeleteBlogs(ctx context.Context, ids []string, purge, allOrNothing bool) ([]*database.BatchResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	results := make([]*database.BatchResult, len(ids))
+	oids := make([]primitive.ObjectID, len(ids))
+	// The same blog may be given more than once, but only
+	// the first time deletes it, as with MongoDB
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	for i, id := range ids {
+		res := &database.BatchResult{}
+		results[i] = res
+
+		oid, err := database.ParseID(id)
+		if err != nil {
+			res.Err = err
+		} else if _, ok := db.deletable(oid, purge); !ok || seen[oid] {
+			res.Err = database.ErrNotFound
+		}
+		if res.Err != nil && allOrNothing {
+			return nil, &database.ItemError{Index: i, Err: res.Err}
+		}
+		if res.Err == nil {
+			oids[i] = oid
+			seen[oid] = true
+		}
+	}
+
+	now := database.Timestamp(database.Now())
+	for i, res := range results {
+		if res.Err != nil {
+			continue
+		}
+		if purge {
+			db.remove(oids[i])
+			continue
+		}
+		data, _ := db.deletable(oids[i], purge)
+		data.DeleteTime = now
+		db.publish(database.Deleted, data)
+	}
+
+	return results, nil
+}
+
+// deletable returns the blog with the given ID if it can be
+// deleted: any blog may be purged, but only blogs which are
+// not in the trash may be moved there.
+func (db *MemoryDatabase) deletable(oid primitive.ObjectID, purge bool) (*blogpb.Blog, bool) {
+	if purge {
+		data, ok := db.blogs[oid]
+		return data, ok
+	}
+	return db.live(oid)
+}
+
+// UpsertBlogs stores several blogs, keeping the IDs they are given.
+func (db *MemoryDatabase) UpsertBlogs(ctx context.Context, blogs []*blogpb.Blog) ([]*database.UpsertResult, error) {
+	now := database.Now()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	results := make([]*database.UpsertResult, len(blogs))
+	for i, blog := range blogs {
+		res := &database.UpsertResult{}
+		results[i] = res
+
+		if blog.GetId() == "" {
+			res.Blog = clone(db.create(blog, database.Timestamp(now)))
+			res.Created = true
+			continue
+		}
+
+		oid, err := database.ParseID(blog.GetId())
+		if err != nil {
+			res.Err = err
+			continue
+		}
+
+		data, ok := db.blogs[oid]
+		switch {
+		case !ok:
+			data = imported(oid, blog, now)
+			db.blogs[oid] = data
+			db.insert(oid)
+			db.publish(database.Created, data)
+			res.Created = true
+		case data.DeleteTime != nil:
+			res.Err = database.ErrInTrash
+			continue
+		case database.SameFields(data, blog):
+			res.Unchanged = true
+		default:
+			db.update(oid, data, blog, database.UpdatableFields)
+		}
+		res.Blog = clone(data)
+	}
+
+	return results, nil
+}
+
+// imported returns a new blog with the given ID and the fields
+// of blog, keeping its timestamps if it has them. Times are kept
+// with the same millisecond precision as MongoDB.
+func imported(oid primitive.ObjectID, blog *blogpb.Blog, now time.Time) *blogpb.Blog {
+	createTime := now
+	if blog.GetCreateTime() != nil {
+		createTime = database.Time(blog.GetCreateTime()).Truncate(time.Millisecond)
+	}
+	updateTime := createTime
+	if blog.GetUpdateTime() != nil {
+		updateTime = database.Time(blog.GetUpdateTime()).Truncate(time.Millisecond)
+	}
+
+	return &blogpb.Blog{
+		Id:         oid.Hex(),
+		AuthorId:   blog.GetAuthorId(),
+		Title:      blog.GetTitle(),
+		Content:    blog.GetContent(),
+		Version:    1,
+		CreateTime: database.Timestamp(createTime),
+		UpdateTime: database.Timestamp(updateTime),
+	}
+}
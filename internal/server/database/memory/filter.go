@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+)
+
+// matcher is the in-memory equivalent of the MongoDB
+// query built for ListBlogs.
+type matcher struct {
+	filter        database.Filter
+	titleContains string
+	sort          database.Sort
+	after         *database.Cursor
+	deleted       bool
+}
+
+// newMatcher creates a matcher for the given options.
+func newMatcher(opts *database.ListOptions) (*matcher, error) {
+	f := opts.Filter
+	for _, id := range []string{f.MinID, f.MaxID} {
+		if id == "" {
+			continue
+		}
+		if _, err := database.ParseID(id); err != nil {
+			return nil, err
+		}
+	}
+	if opts.After != nil {
+		if _, err := database.ParseID(opts.After.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &matcher{
+		filter:        f,
+		titleContains: strings.ToLower(f.TitleContains),
+		sort:          opts.Sort,
+		after:         opts.After,
+		deleted:       opts.Deleted,
+	}, nil
+}
+
+// matches reports whether blog is in the trash if and only if
+// deleted blogs are listed, satisfies the filter and comes after
+// the cursor, if any.
+func (m *matcher) matches(blog *blogpb.Blog) bool {
+	f := m.filter
+	id := blog.GetId()
+	switch {
+	case (blog.GetDeleteTime() != nil) != m.deleted:
+		return false
+	case f.MinID != "" && id < f.MinID:
+		return false
+	case f.MaxID != "" && id >= f.MaxID:
+		return false
+	case f.AuthorID != "" && blog.GetAuthorId() != f.AuthorID:
+		return false
+	case f.TitlePrefix != "" && !strings.HasPrefix(blog.GetTitle(), f.TitlePrefix):
+		return false
+	case m.titleContains != "" && !strings.Contains(strings.ToLower(blog.GetTitle()), m.titleContains):
+		return false
+	case !inRange(database.Time(blog.GetCreateTime()), f.MinCreateTime, f.MaxCreateTime):
+		return false
+	case !inRange(database.Time(blog.GetUpdateTime()), f.MinUpdateTime, f.MaxUpdateTime):
+		return false
+	case m.after != nil:
+		value := database.SortValue(blog, m.sort.Field)
+		return compare(m.sort, value, id, m.after.Value, m.after.ID) > 0
+	}
+	return true
+}
+
+// inRange reports whether t is at or after min and before max,
+// where a zero min or max is unbounded.
+func inRange(t, min, max time.Time) bool {
+	if !min.IsZero() && t.Before(min) {
+		return false
+	}
+	if !max.IsZero() && !t.Before(max) {
+		return false
+	}
+	return true
+}
+
+// sortBlogs sorts blogs in the given order.
+func sortBlogs(blogs []*blogpb.Blog, s database.Sort) {
+	sort.Slice(blogs, func(i, j int) bool {
+		a, b := blogs[i], blogs[j]
+		return compare(s,
+			database.SortValue(a, s.Field), a.GetId(),
+			database.SortValue(b, s.Field), b.GetId(),
+		) < 0
+	})
+}
+
+// compare compares the positions of two blogs in the
+// given order, based on their sort values and IDs.
+// Lowercase hex IDs compare the same as ObjectIDs,
+// and cursor times compare the same as times.
+func compare(s database.Sort, aValue, aID, bValue, bID string) int {
+	c := strings.Compare(aValue, bValue)
+	if c == 0 {
+		c = strings.Compare(aID, bID)
+	}
+	if s.Descending {
+		c = -c
+	}
+	return c
+}
@@ -0,0 +1,322 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryDatabase represents a Database object which
+// keeps all blogs in process memory. It is safe for
+// concurrent use and is intended for tests and local
+// development where no MongoDB instance is available.
+type MemoryDatabase struct {
+	mu    sync.RWMutex
+	blogs map[primitive.ObjectID]*blogpb.Blog
+	// The IDs of the stored blogs in ascending order,
+	// matching the order of MongoDB's _id index.
+	order []primitive.ObjectID
+	// The revisions of each blog in ascending version order
+	revisions map[primitive.ObjectID][]*blogpb.BlogRevision
+	// The recent changes, for WatchBlogs
+	changes *broadcaster
+}
+
+// New creates a new, empty MemoryDatabase.
+func New() *MemoryDatabase {
+	return &MemoryDatabase{
+		blogs:     make(map[primitive.ObjectID]*blogpb.Blog),
+		revisions: make(map[primitive.ObjectID][]*blogpb.BlogRevision),
+		changes:   newBroadcaster(),
+	}
+}
+
+// Endpoint returns the endpoint of the database.
+func (db *MemoryDatabase) Endpoint() string {
+	return "memory://"
+}
+
+// Connect is a no-op for the MemoryDatabase.
+func (db *MemoryDatabase) Connect(ctx context.Context) error {
+	return nil
+}
+
+// Disconnect is a no-op for the MemoryDatabase.
+func (db *MemoryDatabase) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+// Ping always succeeds for the MemoryDatabase.
+func (db *MemoryDatabase) Ping(ctx context.Context) error {
+	return nil
+}
+
+// CreateBlog creates a blog in the database
+func (db *MemoryDatabase) CreateBlog(ctx context.Context, blog *blogpb.Blog) (*blogpb.Blog, error) {
+	db.mu.Lock()
+	data := db.create(blog, database.Timestamp(database.Now()))
+	db.mu.Unlock()
+
+	return clone(data), nil
+}
+
+// create stores a new blog with the client-supplied fields of blog.
+func (db *MemoryDatabase) create(blog *blogpb.Blog, now *timestamp.Timestamp) *blogpb.Blog {
+	oid := primitive.NewObjectID()
+	data := &blogpb.Blog{
+		Id:         oid.Hex(),
+		AuthorId:   blog.GetAuthorId(),
+		Title:      blog.GetTitle(),
+		Content:    blog.GetContent(),
+		Version:    1,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+
+	db.blogs[oid] = data
+	db.insert(oid)
+	db.publish(database.Created, data)
+
+	return data
+}
+
+// ReadBlog reads a blog from the database
+func (db *MemoryDatabase) ReadBlog(ctx context.Context, id string) (*blogpb.Blog, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	data, ok := db.live(oid)
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+
+	return clone(data), nil
+}
+
+// UpdateBlog updates the given fields of a blog in the database.
+func (db *MemoryDatabase) UpdateBlog(ctx context.Context, blog *blogpb.Blog, fields []database.Field, expectedVersion int64) (*blogpb.Blog, error) {
+	oid, err := database.ParseID(blog.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	data, ok := db.live(oid)
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	if expectedVersion != 0 && data.Version != expectedVersion {
+		return nil, database.ErrVersionMismatch
+	}
+
+	db.update(oid, data, blog, fields)
+
+	return clone(data), nil
+}
+
+// update applies the given fields of blog to the stored blog
+// data, after recording the replaced version as a revision.
+func (db *MemoryDatabase) update(oid primitive.ObjectID, data, blog *blogpb.Blog, fields []database.Field) {
+	// Record the replaced version as a revision
+	now := database.Now()
+	db.revisions[oid] = append(db.revisions[oid], &blogpb.BlogRevision{
+		Blog:        clone(data),
+		ReplaceTime: database.Timestamp(now),
+	})
+
+	// Update the masked variables on the document
+	for _, field := range fields {
+		switch field {
+		case database.FieldAuthorID:
+			data.AuthorId = blog.GetAuthorId()
+		case database.FieldTitle:
+			data.Title = blog.GetTitle()
+		case database.FieldContent:
+			data.Content = blog.GetContent()
+		}
+	}
+	data.Version++
+	data.UpdateTime = database.Timestamp(now)
+	db.publish(database.Updated, data)
+}
+
+// DeleteBlog moves a blog to the trash.
+func (db *MemoryDatabase) DeleteBlog(ctx context.Context, id string, expectedVersion int64) (blogpb.DeleteBlogResponse_DeleteStatus, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	data, ok := db.live(oid)
+	if !ok {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, database.ErrNotFound
+	}
+	if expectedVersion != 0 && data.Version != expectedVersion {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, database.ErrVersionMismatch
+	}
+
+	data.DeleteTime = database.Timestamp(database.Now())
+	db.publish(database.Deleted, data)
+
+	return blogpb.DeleteBlogResponse_DELETED, nil
+}
+
+// PurgeBlog removes a blog and its revisions from the database.
+func (db *MemoryDatabase) PurgeBlog(ctx context.Context, id string, expectedVersion int64) (blogpb.DeleteBlogResponse_DeleteStatus, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	data, ok := db.blogs[oid]
+	if !ok {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, database.ErrNotFound
+	}
+	if expectedVersion != 0 && data.Version != expectedVersion {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, database.ErrVersionMismatch
+	}
+
+	db.remove(oid)
+
+	return blogpb.DeleteBlogResponse_DELETED, nil
+}
+
+// UndeleteBlog restores a blog from the trash.
+func (db *MemoryDatabase) UndeleteBlog(ctx context.Context, id string) (*blogpb.Blog, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	data, ok := db.blogs[oid]
+	if !ok || data.DeleteTime == nil {
+		return nil, database.ErrNotFound
+	}
+
+	data.DeleteTime = nil
+	db.publish(database.Created, data)
+
+	return clone(data), nil
+}
+
+// PurgeDeletedBlogs removes the blogs which were moved
+// to the trash before the given time.
+func (db *MemoryDatabase) PurgeDeletedBlogs(ctx context.Context, before time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var expired []primitive.ObjectID
+	for oid, data := range db.blogs {
+		if data.DeleteTime != nil && database.Time(data.DeleteTime).Before(before) {
+			expired = append(expired, oid)
+		}
+	}
+	for _, oid := range expired {
+		db.remove(oid)
+	}
+
+	return len(expired), nil
+}
+
+// ListBlogs lists the blogs in the database in the requested order, calling fn for each one.
+func (db *MemoryDatabase) ListBlogs(ctx context.Context, opts *database.ListOptions, fn func(*blogpb.Blog) error) error {
+	if opts == nil {
+		opts = &database.ListOptions{}
+	}
+
+	match, err := newMatcher(opts)
+	if err != nil {
+		return err
+	}
+
+	// Take a snapshot so that the lock is not held while sending
+	db.mu.RLock()
+	var blogs []*blogpb.Blog
+	for _, oid := range db.order {
+		if blog := db.blogs[oid]; match.matches(blog) {
+			blogs = append(blogs, clone(blog))
+		}
+	}
+	db.mu.RUnlock()
+
+	sortBlogs(blogs, opts.Sort)
+
+	for i, blog := range blogs {
+		if opts.Limit > 0 && i == opts.Limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(blog); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// live returns the blog with the given ID if it
+// exists and is not in the trash.
+func (db *MemoryDatabase) live(oid primitive.ObjectID) (*blogpb.Blog, bool) {
+	data, ok := db.blogs[oid]
+	if !ok || data.DeleteTime != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// remove removes a blog and its revisions.
+func (db *MemoryDatabase) remove(oid primitive.ObjectID) {
+	delete(db.blogs, oid)
+	delete(db.revisions, oid)
+	i := db.search(oid)
+	db.order = append(db.order[:i], db.order[i+1:]...)
+	db.publish(database.Deleted, &blogpb.Blog{Id: oid.Hex()})
+}
+
+// search returns the index in db.order at which oid
+// is stored, or would be inserted if it is not present.
+func (db *MemoryDatabase) search(oid primitive.ObjectID) int {
+	return sort.Search(len(db.order), func(i int) bool {
+		return bytes.Compare(db.order[i][:], oid[:]) >= 0
+	})
+}
+
+// insert adds oid to db.order, keeping it sorted.
+func (db *MemoryDatabase) insert(oid primitive.ObjectID) {
+	i := db.search(oid)
+	db.order = append(db.order, primitive.NilObjectID)
+	copy(db.order[i+1:], db.order[i:])
+	db.order[i] = oid
+}
+
+// clone returns a copy of the blog which is safe
+// to hand out to callers.
+func clone(blog *blogpb.Blog) *blogpb.Blog {
+	return proto.Clone(blog).(*blogpb.Blog)
+}
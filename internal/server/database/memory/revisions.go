@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/golang/protobuf/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListBlogRevisions lists the revisions of a blog, newest first.
+func (db *MemoryDatabase) ListBlogRevisions(ctx context.Context, id string, before int64, limit int) ([]*blogpb.BlogRevision, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := db.checkRevisions(oid); err != nil {
+		return nil, err
+	}
+
+	revisions := db.revisions[oid]
+	var res []*blogpb.BlogRevision
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if limit > 0 && len(res) == limit {
+			break
+		}
+		rev := revisions[i]
+		if before > 0 && rev.GetBlog().GetVersion() >= before {
+			continue
+		}
+		res = append(res, cloneRevision(rev))
+	}
+
+	return res, nil
+}
+
+// GetBlogRevision gets the revision of a blog at a version.
+func (db *MemoryDatabase) GetBlogRevision(ctx context.Context, id string, version int64) (*blogpb.BlogRevision, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := db.checkRevisions(oid); err != nil {
+		return nil, err
+	}
+
+	for _, rev := range db.revisions[oid] {
+		if rev.GetBlog().GetVersion() == version {
+			return cloneRevision(rev), nil
+		}
+	}
+
+	return nil, database.ErrNotFound
+}
+
+// checkRevisions checks that the revisions of a blog may be read,
+// which is only while it is live. db.mu must be held.
+func (db *MemoryDatabase) checkRevisions(oid primitive.ObjectID) error {
+	data, ok := db.blogs[oid]
+	switch {
+	case !ok:
+		return database.ErrNotFound
+	case data.DeleteTime != nil:
+		return database.ErrDeleted
+	}
+	return nil
+}
+
+// cloneRevision returns a copy of the revision which
+// is safe to hand out to callers.
+func cloneRevision(rev *blogpb.BlogRevision) *blogpb.BlogRevision {
+	return proto.Clone(rev).(*blogpb.BlogRevision)
+}
@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+)
+
+// SearchBlogs searches the title and content of the blogs in the database,
+// returning at most limit results, most relevant first.
+//
+// Blogs are scored by counting the occurrences of each search term,
+// weighting matches in the title above matches in the content.
+func (db *MemoryDatabase) SearchBlogs(ctx context.Context, query string, limit int) ([]*database.SearchResult, error) {
+	terms := make(map[string]bool)
+	for _, term := range database.Tokenize(query) {
+		terms[term] = true
+	}
+
+	db.mu.RLock()
+	var results []*database.SearchResult
+	for _, oid := range db.order {
+		blog := db.blogs[oid]
+		if blog.GetDeleteTime() != nil {
+			continue
+		}
+		score := float64(database.TitleWeight*countTerms(blog.GetTitle(), terms) +
+			database.ContentWeight*countTerms(blog.GetContent(), terms))
+		if score > 0 {
+			results = append(results, &database.SearchResult{
+				Blog:  clone(blog),
+				Score: score,
+			})
+		}
+	}
+	db.mu.RUnlock()
+
+	// Blogs are already in ID order, which breaks ties
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// countTerms counts the tokens of text which are search terms.
+func countTerms(text string, terms map[string]bool) int {
+	n := 0
+	for _, tok := range database.Tokenize(text) {
+		if terms[tok] {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+)
+
+// How many recent changes are kept for watchers to resume from
+const maxChangeHistory = 10000
+
+// broadcaster keeps the recent changes to the blogs and wakes
+// the watchers when there are new ones. Each change is numbered,
+// and its number is its resume token.
+type broadcaster struct {
+	mu sync.Mutex
+	// The most recent changes, oldest first
+	changes []*database.Change
+	// The number of the next change
+	next int64
+	// Closed and replaced whenever a change is published
+	notify chan struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{notify: make(chan struct{})}
+}
+
+// publish records a change and wakes the watchers.
+func (b *broadcaster) publish(typ database.ChangeType, blog *blogpb.Blog) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.changes = append(b.changes, &database.Change{
+		Type:        typ,
+		Blog:        blog,
+		ResumeToken: strconv.FormatInt(b.next, 10),
+	})
+	b.next++
+	if len(b.changes) > maxChangeHistory {
+		b.changes = b.changes[len(b.changes)-maxChangeHistory:]
+	}
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// start returns the number of the first change after
+// the one with the given resume token.
+func (b *broadcaster) start(token string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if token == "" {
+		return b.next, nil
+	}
+	n, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || n < 0 || n >= b.next {
+		return 0, database.ErrInvalidResumeToken
+	}
+	return n + 1, nil
+}
+
+// since returns the changes from number n onwards, along with
+// a channel which is closed when there are more.
+func (b *broadcaster) since(n int64) ([]*database.Change, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	oldest := b.next - int64(len(b.changes))
+	if n < oldest {
+		return nil, nil, database.ErrResumeTokenExpired
+	}
+	return b.changes[n-oldest:], b.notify, nil
+}
+
+// WatchBlogs watches the changes to blogs. The most recent changes
+// are kept in memory, so that watchers can resume after them.
+func (db *MemoryDatabase) WatchBlogs(ctx context.Context, opts *database.WatchOptions, fn func(*database.Change) error) error {
+	if opts == nil {
+		opts = &database.WatchOptions{}
+	}
+
+	next, err := db.changes.start(opts.ResumeToken)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// A watcher which falls too far behind expires like its resume token
+		changes, notify, err := db.changes.since(next)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			next++
+			if !opts.Matches(change) {
+				continue
+			}
+			if err := fn(cloneChange(change)); err != nil {
+				return err
+			}
+		}
+
+		if len(changes) == 0 {
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// publish reports a change to a stored blog to the watchers.
+// It must be called with db.mu held, so that changes are
+// published in the order they are made.
+func (db *MemoryDatabase) publish(typ database.ChangeType, data *blogpb.Blog) {
+	db.changes.publish(typ, clone(data))
+}
+
+// cloneChange returns a copy of the change which is
+// safe to hand out to callers.
+func cloneChange(change *database.Change) *database.Change {
+	c := *change
+	c.Blog = clone(change.Blog)
+	return &c
+}
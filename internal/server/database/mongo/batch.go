@@ -0,0 +1,355 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// inTransaction runs fn in a transaction, which is committed if
+// fn succeeds and aborted otherwise.
+func (db *MongoDatabase) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	sess, err := db.client.StartSession()
+	if err != nil {
+		return translateError(err)
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// CreateBlogs creates several blogs with a single InsertMany.
+//
+// If allOrNothing is set the insert runs in a transaction where the
+// deployment supports it. Elsewhere the blogs which were inserted
+// before the failure are deleted again.
+func (db *MongoDatabase) CreateBlogs(ctx context.Context, blogs []*blogpb.Blog, allOrNothing bool) ([]*database.BatchResult, error) {
+	now := database.Now()
+	results := make([]*database.BatchResult, len(blogs))
+	docs := make([]interface{}, len(blogs))
+	ids := make(bson.A, len(blogs))
+	for i, blog := range blogs {
+		// IDs are assigned up front so that results can be built without the insert results
+		data := &blogItem{
+			ID:         primitive.NewObjectID(),
+			AuthorID:   blog.GetAuthorId(),
+			Title:      blog.GetTitle(),
+			Content:    blog.GetContent(),
+			Version:    1,
+			CreateTime: now,
+			UpdateTime: now,
+		}
+		results[i] = &database.BatchResult{Blog: data.blog()}
+		docs[i] = data
+		ids[i] = data.ID
+	}
+
+	if len(docs) == 0 {
+		return results, nil
+	}
+
+	if !allOrNothing {
+		_, err := db.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err == nil {
+			return results, nil
+		}
+
+		var bwe mongo.BulkWriteException
+		if !errors.As(err, &bwe) || len(bwe.WriteErrors) == 0 {
+			return nil, translateError(err)
+		}
+		for _, we := range bwe.WriteErrors {
+			results[we.Index] = &database.BatchResult{Err: writeError(we)}
+		}
+		return results, nil
+	}
+
+	insert := func(ctx context.Context) error {
+		_, err := db.collection.InsertMany(ctx, docs)
+		return err
+	}
+
+	var err error
+	if db.replicated {
+		err = db.inTransaction(ctx, insert)
+	} else if err = insert(ctx); err != nil {
+		if _, derr := db.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); derr != nil {
+			return nil, errors.Wrapf(translateError(derr), "Error undoing failed batch (%v)", err)
+		}
+	}
+	if err != nil {
+		return nil, batchError(err)
+	}
+
+	return results, nil
+}
+
+// ReadBlogs reads several blogs with a single find.
+func (db *MongoDatabase) ReadBlogs(ctx context.Context, ids []string) ([]*database.BatchResult, error) {
+	results := make([]*database.BatchResult, len(ids))
+	oids := make([]primitive.ObjectID, len(ids))
+	valid := bson.A{}
+	for i, id := range ids {
+		oid, err := database.ParseID(id)
+		if err != nil {
+			results[i] = &database.BatchResult{Err: err}
+			continue
+		}
+		oids[i] = oid
+		valid = append(valid, oid)
+	}
+
+	found, err := db.findBlogs(ctx, bson.M{"_id": bson.M{"$in": valid}, "delete_time": nil}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ids {
+		if results[i] != nil {
+			continue
+		}
+		if data, ok := found[oids[i]]; ok {
+			results[i] = &database.BatchResult{Blog: data.blog()}
+		} else {
+			results[i] = &database.BatchResult{Err: database.ErrNotFound}
+		}
+	}
+
+	return results, nil
+}
+
+// DeleteBlogs moves several blogs to the trash with a single
+// UpdateMany or, if purge is set, removes them and their revisions
+// with DeleteMany.
+//
+// If allOrNothing is set every blog is checked before any is deleted,
+// and the whole batch runs in a transaction where the deployment
+// supports it.
+func (db *MongoDatabase) DeleteBlogs(ctx context.Context, ids []string, purge, allOrNothing bool) ([]*database.BatchResult, error) {
+	var results []*database.BatchResult
+	deleteBlogs := func(ctx context.Context) error {
+		var err error
+		results, err = db.deleteBlogs(ctx, ids, purge, allOrNothing)
+		return err
+	}
+
+	var err error
+	if allOrNothing && db.replicated {
+		err = db.inTransaction(ctx, deleteBlogs)
+	} else {
+		err = deleteBlogs(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (db *MongoDatabase) deleteBlogs(ctx context.Context, ids []string, purge, allOrNothing bool) ([]*database.BatchResult, error) {
+	results := make([]*database.BatchResult, len(ids))
+	oids := make([]primitive.ObjectID, len(ids))
+	valid := bson.A{}
+	for i, id := range ids {
+		oid, err := database.ParseID(id)
+		if err != nil {
+			results[i] = &database.BatchResult{Err: err}
+			continue
+		}
+		oids[i] = oid
+		valid = append(valid, oid)
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": valid}}
+	if !purge {
+		filter["delete_time"] = nil
+	}
+	found, err := db.findBlogs(ctx, filter, bson.M{"_id": 1})
+	if err != nil {
+		return nil, err
+	}
+
+	var targets bson.A
+	for i := range ids {
+		if results[i] == nil {
+			if _, ok := found[oids[i]]; ok {
+				// The same blog may be given more than once
+				delete(found, oids[i])
+				targets = append(targets, oids[i])
+				results[i] = &database.BatchResult{}
+			} else {
+				results[i] = &database.BatchResult{Err: database.ErrNotFound}
+			}
+		}
+		if results[i].Err != nil && allOrNothing {
+			return nil, &database.ItemError{Index: i, Err: results[i].Err}
+		}
+	}
+
+	if len(targets) == 0 {
+		return results, nil
+	}
+
+	filter = bson.M{"_id": bson.M{"$in": targets}}
+	if purge {
+		if _, err := db.collection.DeleteMany(ctx, filter); err != nil {
+			return nil, translateError(err)
+		}
+		if _, err := db.revisions.DeleteMany(ctx, bson.M{"blog_id": bson.M{"$in": targets}}); err != nil {
+			return nil, translateError(err)
+		}
+	} else {
+		filter["delete_time"] = nil
+		update := bson.M{"$set": bson.M{"delete_time": database.Now()}}
+		if _, err := db.collection.UpdateMany(ctx, filter, update); err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	return results, nil
+}
+
+// UpsertBlogs stores several blogs, keeping the IDs they are given.
+// New blogs are written with a single unordered InsertMany, while
+// existing blogs are updated one at a time with UpdateBlog, so that
+// their revisions are recorded.
+func (db *MongoDatabase) UpsertBlogs(ctx context.Context, blogs []*blogpb.Blog) ([]*database.UpsertResult, error) {
+	results := make([]*database.UpsertResult, len(blogs))
+	oids := make([]primitive.ObjectID, len(blogs))
+	valid := bson.A{}
+	for i, blog := range blogs {
+		if blog.GetId() == "" {
+			oids[i] = primitive.NewObjectID()
+			continue
+		}
+		oid, err := database.ParseID(blog.GetId())
+		if err != nil {
+			results[i] = &database.UpsertResult{Err: err}
+			continue
+		}
+		oids[i] = oid
+		valid = append(valid, oid)
+	}
+
+	existing, err := db.findBlogs(ctx, bson.M{"_id": bson.M{"$in": valid}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := database.Now()
+
+	// The new blogs, along with their index in blogs
+	var docs []interface{}
+	var indexes []int
+	for i, blog := range blogs {
+		if results[i] != nil {
+			continue
+		}
+
+		current, ok := existing[oids[i]]
+		switch {
+		case !ok:
+			data := importedItem(oids[i], blog, now)
+			results[i] = &database.UpsertResult{Blog: data.blog(), Created: true}
+			docs = append(docs, data)
+			indexes = append(indexes, i)
+		case !current.DeleteTime.IsZero():
+			results[i] = &database.UpsertResult{Err: database.ErrInTrash}
+		case database.SameFields(current.blog(), blog):
+			results[i] = &database.UpsertResult{Blog: current.blog(), Unchanged: true}
+		default:
+			res, err := db.UpdateBlog(ctx, blog, database.UpdatableFields, 0)
+			results[i] = &database.UpsertResult{Blog: res, Err: err}
+		}
+	}
+
+	if len(docs) == 0 {
+		return results, nil
+	}
+
+	_, err = db.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		var bwe mongo.BulkWriteException
+		if !errors.As(err, &bwe) || len(bwe.WriteErrors) == 0 {
+			return nil, translateError(err)
+		}
+		for _, we := range bwe.WriteErrors {
+			results[indexes[we.Index]] = &database.UpsertResult{Err: writeError(we)}
+		}
+	}
+
+	return results, nil
+}
+
+// importedItem returns a new blog item with the given ID and the
+// fields of blog, keeping its timestamps if it has them. Times
+// are stored with millisecond precision.
+func importedItem(oid primitive.ObjectID, blog *blogpb.Blog, now time.Time) *blogItem {
+	createTime := now
+	if blog.GetCreateTime() != nil {
+		createTime = database.Time(blog.GetCreateTime()).Truncate(time.Millisecond)
+	}
+	updateTime := createTime
+	if blog.GetUpdateTime() != nil {
+		updateTime = database.Time(blog.GetUpdateTime()).Truncate(time.Millisecond)
+	}
+
+	return &blogItem{
+		ID:         oid,
+		AuthorID:   blog.GetAuthorId(),
+		Title:      blog.GetTitle(),
+		Content:    blog.GetContent(),
+		Version:    1,
+		CreateTime: createTime,
+		UpdateTime: updateTime,
+	}
+}
+
+// findBlogs finds the blogs matching filter, keyed by ID.
+func (db *MongoDatabase) findBlogs(ctx context.Context, filter, projection bson.M) (map[primitive.ObjectID]*blogItem, error) {
+	opts := options.Find()
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	cur, err := db.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	var items []*blogItem
+	if err := cur.All(ctx, &items); err != nil {
+		return nil, translateError(err)
+	}
+
+	found := make(map[primitive.ObjectID]*blogItem, len(items))
+	for _, item := range items {
+		found[item.ID] = item
+	}
+	return found, nil
+}
+
+// writeError translates a single error of a bulk write.
+func writeError(we mongo.BulkWriteError) error {
+	return translateError(mongo.WriteException{WriteErrors: mongo.WriteErrors{we.WriteError}})
+}
+
+// batchError translates the error of an all-or-nothing bulk write,
+// identifying the failing item where the error names one.
+func batchError(err error) error {
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && len(bwe.WriteErrors) > 0 {
+		we := bwe.WriteErrors[0]
+		return &database.ItemError{Index: we.Index, Err: writeError(we)}
+	}
+	return translateError(err)
+}
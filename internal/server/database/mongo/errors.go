@@ -0,0 +1,91 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// The server error code for duplicate keys
+const duplicateKeyCode = 11000
+
+// translateError converts errors from the MongoDB driver to the
+// matching database error, if any, keeping the driver's message
+// where it adds detail.
+func translateError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return database.ErrNotFound
+	case isDuplicateKey(err):
+		return fmt.Errorf("%w: %v", database.ErrConflict, err)
+	case isUnavailable(err):
+		return fmt.Errorf("%w: %v", database.ErrUnavailable, err)
+	default:
+		return err
+	}
+}
+
+// isDuplicateKey reports whether err is a duplicate key write error.
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) {
+		for _, e := range bwe.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && ce.Code == duplicateKeyCode
+}
+
+// Server error codes for a deployment which is unreachable,
+// shutting down or electing a new primary. These are transient:
+// the same call is likely to succeed once the deployment recovers
+// or a new primary is elected. Reporting them as ErrUnavailable
+// rather than as unknown errors affects every caller: clients get
+// Unavailable (503 through the gateway) instead of Internal, which
+// tells them to retry, and the resilience decorator retries reads
+// and counts them towards its circuit breaker.
+var unavailableCodes = map[int32]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	9001:  true, // SocketException
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+}
+
+// isUnavailable reports whether err was caused by
+// the deployment being unreachable.
+func isUnavailable(err error) bool {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) && (ce.HasErrorLabel("NetworkError") || unavailableCodes[ce.Code]) {
+		return true
+	}
+	var conn topology.ConnectionError
+	if errors.As(err, &conn) {
+		return true
+	}
+	return errors.Is(err, mongo.ErrClientDisconnected) ||
+		strings.HasPrefix(err.Error(), "server selection error")
+}
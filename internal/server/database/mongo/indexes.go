@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexSpec describes an index which should exist on the blog collection.
+type indexSpec struct {
+	Name string
+	// The keys of a regular index, in order.
+	Keys bson.D
+	// The weighted fields of a text index. A spec must
+	// set exactly one of Keys and Weights.
+	Weights bson.D
+	Unique  bool
+}
+
+// blogIndexes is the set of indexes which the blog collection
+// should have, besides the default index on _id.
+var blogIndexes = []indexSpec{
+	{
+		// Listing by author in ID order
+		Name: "blog_author",
+		Keys: bson.D{{Key: "author_id", Value: 1}, {Key: "_id", Value: 1}},
+	},
+	{
+		// Sorting by title and matching title prefixes
+		Name: "blog_title",
+		Keys: bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}},
+	},
+	{
+		// Sorting and filtering by creation time
+		Name: "blog_create_time",
+		Keys: bson.D{{Key: "create_time", Value: 1}, {Key: "_id", Value: 1}},
+	},
+	{
+		// Sorting and filtering by update time
+		Name: "blog_update_time",
+		Keys: bson.D{{Key: "update_time", Value: 1}, {Key: "_id", Value: 1}},
+	},
+	{
+		// Listing the trash and purging expired blogs
+		Name: "blog_delete_time",
+		Keys: bson.D{{Key: "delete_time", Value: 1}},
+	},
+	{
+		// Full-text search over the title and content
+		Name: "blog_text",
+		Weights: bson.D{
+			{Key: "title", Value: database.TitleWeight},
+			{Key: "content", Value: database.ContentWeight},
+		},
+	},
+}
+
+// revisionIndexes is the set of indexes which the revisions
+// collection should have, besides the default index on _id.
+var revisionIndexes = []indexSpec{
+	{
+		// Listing the revisions of a blog, newest first, and
+		// recording each version of a blog at most once
+		Name:   "revision_blog_version",
+		Keys:   bson.D{{Key: "blog_id", Value: 1}, {Key: "version", Value: -1}},
+		Unique: true,
+	},
+}
+
+// model returns the index model used to create the index.
+func (spec *indexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(spec.Name)
+	if spec.Unique {
+		opts.SetUnique(true)
+	}
+
+	keys := spec.Keys
+	if len(spec.Weights) > 0 {
+		keys = bson.D{}
+		for _, w := range spec.Weights {
+			keys = append(keys, bson.E{Key: w.Key, Value: "text"})
+		}
+		opts.SetWeights(spec.Weights)
+	}
+
+	return mongo.IndexModel{Keys: keys, Options: opts}
+}
+
+// indexInfo is an index as reported by the server.
+type indexInfo struct {
+	Name    string `bson:"name"`
+	Key     bson.D `bson:"key"`
+	Weights bson.M `bson:"weights"`
+	Unique  bool   `bson:"unique"`
+}
+
+// matches reports whether the existing index is equivalent to spec.
+func (spec *indexSpec) matches(info *indexInfo) bool {
+	if spec.Unique != info.Unique {
+		return false
+	}
+
+	// Text indexes are stored with internal keys, so only their weights are compared
+	if len(spec.Weights) > 0 {
+		if len(spec.Weights) != len(info.Weights) {
+			return false
+		}
+		for _, w := range spec.Weights {
+			if !equalNumbers(w.Value, info.Weights[w.Key]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if len(spec.Keys) != len(info.Key) {
+		return false
+	}
+	for i, k := range spec.Keys {
+		if k.Key != info.Key[i].Key || !equalNumbers(k.Value, info.Key[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalNumbers compares two numbers which may have
+// been decoded from BSON with different types.
+func equalNumbers(a, b interface{}) bool {
+	return fmt.Sprint(toFloat(a)) == fmt.Sprint(toFloat(b))
+}
+
+func toFloat(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	default:
+		return v
+	}
+}
+
+// IndexOptions controls how the indexes of the blog and revisions
+// collections are reconciled with the declared index specs on Connect.
+type IndexOptions struct {
+	// Create indexes which are declared but missing.
+	Ensure bool
+	// Drop indexes which are not declared.
+	DropUnknown bool
+}
+
+// syncIndexes reconciles the indexes of a collection with specs,
+// logging every difference it finds and every change it makes.
+// Indexes which exist with different keys or options are reported
+// but never modified.
+func syncIndexes(ctx context.Context, coll *mongo.Collection, specs []indexSpec, opts IndexOptions) error {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Error listing indexes")
+	}
+	var existing []*indexInfo
+	if err := cur.All(ctx, &existing); err != nil {
+		return errors.Wrap(err, "Error decoding indexes")
+	}
+
+	byName := make(map[string]*indexInfo)
+	for _, info := range existing {
+		byName[info.Name] = info
+	}
+
+	declared := make(map[string]bool)
+	for i := range specs {
+		spec := &specs[i]
+		declared[spec.Name] = true
+
+		info, ok := byName[spec.Name]
+		switch {
+		case ok && spec.matches(info):
+			continue
+		case ok:
+			log.Printf("Index %s.%s differs from its spec (keys %v, weights %v); leaving it unchanged", coll.Name(), info.Name, info.Key, info.Weights)
+		case !opts.Ensure:
+			log.Printf("Index %s.%s is missing", coll.Name(), spec.Name)
+		default:
+			if _, err := coll.Indexes().CreateOne(ctx, spec.model()); err != nil {
+				return errors.Wrapf(err, "Error creating index %s", spec.Name)
+			}
+			log.Printf("Created index %s.%s", coll.Name(), spec.Name)
+		}
+	}
+
+	for _, info := range existing {
+		if declared[info.Name] || info.Name == "_id_" {
+			continue
+		}
+		if !opts.DropUnknown {
+			log.Printf("Index %s.%s is not declared", coll.Name(), info.Name)
+			continue
+		}
+		if _, err := coll.Indexes().DropOne(ctx, info.Name); err != nil {
+			return errors.Wrapf(err, "Error dropping index %s", info.Name)
+		}
+		log.Printf("Dropped index %s.%s", coll.Name(), info.Name)
+	}
+
+	return nil
+}
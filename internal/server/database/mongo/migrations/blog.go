@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Blog is the list of migrations for the blog database.
+// New migrations must be appended with a higher version
+// than every existing one, and must never be edited once
+// they have been released.
+var Blog = []Migration{
+	{
+		Version:     1,
+		Description: "Add version to blogs",
+		Up: func(ctx context.Context, blogs *mongo.Collection) error {
+			_, err := blogs.UpdateMany(ctx,
+				bson.M{"version": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"version": 1}},
+			)
+			return err
+		},
+		Down: func(ctx context.Context, blogs *mongo.Collection) error {
+			_, err := blogs.UpdateMany(ctx,
+				bson.M{},
+				bson.M{"$unset": bson.M{"version": ""}},
+			)
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "Add create and update times to blogs",
+		Up:          addTimes,
+		Down: func(ctx context.Context, blogs *mongo.Collection) error {
+			_, err := blogs.UpdateMany(ctx,
+				bson.M{},
+				bson.M{"$unset": bson.M{"create_time": "", "update_time": ""}},
+			)
+			return err
+		},
+	},
+}
+
+// addTimes sets the create and update times of blogs which
+// have none to the creation time of their ObjectID.
+func addTimes(ctx context.Context, blogs *mongo.Collection) error {
+	filter := bson.M{"create_time": bson.M{"$exists": false}}
+	cur, err := blogs.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+
+		created := doc.ID.Timestamp()
+		_, err := blogs.UpdateOne(ctx,
+			bson.M{"_id": doc.ID},
+			bson.M{"$set": bson.M{"create_time": created, "update_time": created}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
@@ -0,0 +1,401 @@
+// Package migrations applies versioned schema migrations to the
+// blog database in MongoDB.
+//
+// Applied versions are recorded in the schema_migrations collection.
+// A lock document in the schema_migrations_lock collection, renewed
+// while a migration runs, ensures that only one server instance
+// migrates at a time.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// The collection recording applied migrations
+	migrationsCollection = "schema_migrations"
+	// The collection holding the migration lock
+	lockCollection = "schema_migrations_lock"
+	// The _id of the lock document
+	lockID = "migrate"
+	// How long a lock is held before other instances may take it
+	// over, unless its holder renews it
+	lockTTL = 10 * time.Minute
+	// How often the holder of the lock renews it
+	lockRenewInterval = lockTTL / 5
+	// How often a locked migration is retried
+	lockRetryInterval = time.Second
+)
+
+var (
+	// ErrLocked is returned when another instance holds the
+	// migration lock and the context expires while waiting.
+	ErrLocked = errors.New("Migrations are locked by another instance")
+
+	// ErrLockLost is returned when the migration lock could not be
+	// renewed, so another instance may have taken it over. The
+	// migration in progress is cancelled.
+	ErrLockLost = errors.New("Lost the migration lock")
+
+	// ErrNoDown is returned when rolling back past a
+	// migration which has no down-step.
+	ErrNoDown = errors.New("Migration cannot be rolled back")
+)
+
+// Migration is a single versioned change to the database.
+type Migration struct {
+	// The version of the migration. Versions must be
+	// positive and unique, and are applied in ascending order.
+	Version int
+	// A short description of the change.
+	Description string
+	// Applies the migration to the blog collection.
+	Up func(ctx context.Context, blogs *mongo.Collection) error
+	// Reverts the migration. It may be nil if the
+	// migration cannot be rolled back.
+	Down func(ctx context.Context, blogs *mongo.Collection) error
+}
+
+// Status is the state of a single migration.
+type Status struct {
+	Migration *Migration
+	// When the migration was applied, or nil if it is pending.
+	AppliedAt *time.Time
+}
+
+// A record of an applied migration
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	blogs      *mongo.Collection
+	db         *mongo.Database
+	migrations []*Migration
+	owner      string
+}
+
+// New creates a Migrator applying the given migrations to the
+// blog collection. The migrations are checked for valid and
+// unique versions.
+func New(blogs *mongo.Collection, migrations []Migration) (*Migrator, error) {
+	m := &Migrator{
+		blogs: blogs,
+		db:    blogs.Database(),
+		owner: owner(),
+	}
+
+	seen := make(map[int]bool)
+	for i := range migrations {
+		mig := &migrations[i]
+		switch {
+		case mig.Version <= 0:
+			return nil, fmt.Errorf("Invalid migration version %d", mig.Version)
+		case seen[mig.Version]:
+			return nil, fmt.Errorf("Duplicate migration version %d", mig.Version)
+		case mig.Up == nil:
+			return nil, fmt.Errorf("Migration %d has no up-step", mig.Version)
+		}
+		seen[mig.Version] = true
+		m.migrations = append(m.migrations, mig)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+
+	return m, nil
+}
+
+// Latest returns the highest known migration version, or zero if there are none.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status returns the state of every known migration, in version order.
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		statuses[i] = &Status{Migration: mig}
+		if rec, ok := applied[mig.Version]; ok {
+			statuses[i].AppliedAt = &rec.AppliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// Up applies every pending migration with a version up to and
+// including target, in ascending order. A target of zero applies
+// all pending migrations. It returns the versions which were applied.
+func (m *Migrator) Up(ctx context.Context, target int) ([]int, error) {
+	if target == 0 {
+		target = m.Latest()
+	}
+
+	var done []int
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if mig.Version > target {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+
+			if err := mig.Up(ctx, m.blogs); err != nil {
+				return errors.Wrapf(err, "Error applying migration %d", mig.Version)
+			}
+
+			rec := &record{
+				Version:     mig.Version,
+				Description: mig.Description,
+				AppliedAt:   time.Now().UTC(),
+			}
+			if _, err := m.db.Collection(migrationsCollection).InsertOne(ctx, rec); err != nil {
+				return errors.Wrapf(err, "Error recording migration %d", mig.Version)
+			}
+			done = append(done, mig.Version)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down reverts every applied migration with a version greater
+// than target, in descending order. It returns the versions which
+// were reverted. Nothing is reverted if any of those migrations
+// has no down-step.
+func (m *Migrator) Down(ctx context.Context, target int) ([]int, error) {
+	var done []int
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		var pending []*Migration
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if mig.Version <= target {
+				break
+			}
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == nil {
+				return errors.Wrapf(ErrNoDown, "Migration %d", mig.Version)
+			}
+			pending = append(pending, mig)
+		}
+
+		for _, mig := range pending {
+			if err := mig.Down(ctx, m.blogs); err != nil {
+				return errors.Wrapf(err, "Error reverting migration %d", mig.Version)
+			}
+
+			filter := bson.M{"_id": mig.Version}
+			if _, err := m.db.Collection(migrationsCollection).DeleteOne(ctx, filter); err != nil {
+				return errors.Wrapf(err, "Error unrecording migration %d", mig.Version)
+			}
+			done = append(done, mig.Version)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// applied returns the records of the applied migrations by version.
+func (m *Migrator) applied(ctx context.Context) (map[int]*record, error) {
+	cur, err := m.db.Collection(migrationsCollection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing applied migrations")
+	}
+
+	var records []*record
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, errors.Wrap(err, "Error decoding applied migrations")
+	}
+
+	applied := make(map[int]*record)
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+
+	return applied, nil
+}
+
+// withLock calls fn while holding the migration lock, waiting
+// for other instances to release it until ctx is done. The lock is
+// renewed while fn runs, however long it takes. If a renewal fails,
+// the context passed to fn is cancelled and ErrLockLost is returned.
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	for {
+		ok, err := m.tryLock(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrLocked
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	defer m.unlock()
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := make(chan error, 1)
+	renewing := make(chan struct{})
+	go func() {
+		defer close(renewing)
+		if err := m.keepLock(lockCtx); err != nil {
+			lost <- err
+			cancel()
+		}
+	}()
+
+	err := fn(lockCtx)
+	cancel()
+	<-renewing
+
+	// A lost lock explains any error from the cancelled migration
+	select {
+	case lerr := <-lost:
+		return lerr
+	default:
+		return err
+	}
+}
+
+// keepLock renews the migration lock periodically until ctx is
+// done, returning an error wrapping ErrLockLost if a renewal fails.
+func (m *Migrator) keepLock(ctx context.Context) error {
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		err := m.renewLock(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// renewLock extends the expiry of the migration lock, provided
+// that it is still held by this instance.
+func (m *Migrator) renewLock(ctx context.Context) error {
+	filter := bson.M{"_id": lockID, "owner": m.owner}
+	update := bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(lockTTL)}}
+
+	res, err := m.db.Collection(lockCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.Wrapf(ErrLockLost, "Error renewing the migration lock: %v", err)
+	}
+	if res.MatchedCount == 0 {
+		return errors.Wrap(ErrLockLost, "The migration lock was taken over")
+	}
+	return nil
+}
+
+// tryLock attempts to take the migration lock, taking over
+// locks whose holders have not released them in time.
+func (m *Migrator) tryLock(ctx context.Context) (bool, error) {
+	coll := m.db.Collection(lockCollection)
+	now := time.Now().UTC()
+
+	// The lock is held as long as the document exists and has not expired
+	filter := bson.M{"_id": lockID, "expires_at": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{
+		"owner":      m.owner,
+		"expires_at": now.Add(lockTTL),
+	}}
+
+	_, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err == nil {
+		return true, nil
+	}
+
+	// The upsert conflicts with the _id of a lock which has not expired
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+
+	return false, errors.Wrap(err, "Error taking the migration lock")
+}
+
+// unlock releases the migration lock if it is still held by this instance.
+func (m *Migrator) unlock() {
+	// Release the lock even if the migration context was cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": lockID, "owner": m.owner}
+	m.db.Collection(lockCollection).DeleteOne(ctx, filter)
+}
+
+// isDuplicateKey reports whether err is a duplicate key write error.
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// owner identifies this process as the holder of the lock.
+func owner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
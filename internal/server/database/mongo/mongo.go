@@ -2,10 +2,11 @@ package database
 
 import (
 	"context"
-	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,28 +19,62 @@ var (
 	errOidConvert = errors.New("Error converting id to type primitive.ObjectID")
 )
 
+// How many times UpdateBlog tries to apply an update
+// which races with other writes to the same blog
+const maxUpdateAttempts = 3
+
 // MongoDatabase represents a Database object which
-// connects to a MongoDB client at the specified host
-// and port, along with other connection options.
+// connects to a MongoDB deployment at the specified
+// URI or host and port, along with other connection options.
 type MongoDatabase struct {
 	Options    *MongoDatabaseOptions
 	client     *mongo.Client
 	collection *mongo.Collection
-}
-
-// MongoDatabaseOptions specifies the options for
-// connecting to a MongoDatabase.
-type MongoDatabaseOptions struct {
-	Host string
-	Port int
+	revisions  *mongo.Collection
+	// Whether the deployment supports transactions and change streams
+	replicated bool
 }
 
 // A mapping of a blog item to MongoDB types
 type blogItem struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	AuthorID string             `bson:"author_id,omitempty"`
-	Title    string             `bson:"title,omitempty"`
-	Content  string             `bson:"content,omitempty"`
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	AuthorID   string             `bson:"author_id,omitempty"`
+	Title      string             `bson:"title,omitempty"`
+	Content    string             `bson:"content,omitempty"`
+	Version    int64              `bson:"version,omitempty"`
+	CreateTime time.Time          `bson:"create_time,omitempty"`
+	UpdateTime time.Time          `bson:"update_time,omitempty"`
+	DeleteTime time.Time          `bson:"delete_time,omitempty"`
+}
+
+// liveFilter returns a query matching the blog with
+// the given ID if it is not in the trash.
+func liveFilter(oid primitive.ObjectID) bson.M {
+	return bson.M{"_id": oid, "delete_time": nil}
+}
+
+// blog converts a blog item to a Blog. Documents written before
+// blogs had timestamps derive them from the ObjectID.
+func (item *blogItem) blog() *blogpb.Blog {
+	createTime := item.CreateTime
+	if createTime.IsZero() {
+		createTime = item.ID.Timestamp()
+	}
+	updateTime := item.UpdateTime
+	if updateTime.IsZero() {
+		updateTime = createTime
+	}
+
+	return &blogpb.Blog{
+		Id:         item.ID.Hex(),
+		AuthorId:   item.AuthorID,
+		Title:      item.Title,
+		Content:    item.Content,
+		Version:    item.Version,
+		CreateTime: database.Timestamp(createTime),
+		UpdateTime: database.Timestamp(updateTime),
+		DeleteTime: database.Timestamp(item.DeleteTime),
+	}
 }
 
 // New creates a new MongoDatabase with the specified options.
@@ -47,35 +82,78 @@ func New(opts *MongoDatabaseOptions) (*MongoDatabase, error) {
 	db := &MongoDatabase{
 		Options: opts,
 	}
-	client, err := mongo.NewClient(options.Client().ApplyURI(db.Endpoint()).SetConnectTimeout(30 * time.Second))
+	clientOpts, err := opts.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.NewClient(clientOpts.SetConnectTimeout(30 * time.Second))
 	if err != nil {
 		return nil, errors.Wrap(err, "Error instantiating MongoDB client")
 	}
 	db.client = client
-	db.collection = client.Database("mydb").Collection("blog")
+	db.collection = client.Database(opts.databaseName()).Collection(opts.collectionName())
+	db.revisions = client.Database(opts.databaseName()).Collection(opts.revisionsCollectionName())
 	return db, nil
 }
 
-// Endpoint returns the endpoint of the database.
+// Collection returns the MongoDB collection holding the blogs.
+func (db *MongoDatabase) Collection() *mongo.Collection {
+	return db.collection
+}
+
+// Endpoint returns the endpoint of the database,
+// with any password in the URI redacted.
 func (db *MongoDatabase) Endpoint() string {
-	return fmt.Sprintf("mongodb://%s:%d", db.Options.Host, db.Options.Port)
+	return redact(db.Options.uri())
 }
 
 // Connect connects to and pings the MongoDatabase.
 func (db *MongoDatabase) Connect(ctx context.Context) error {
 	// Connect to MongoDB client
 	if err := db.client.Connect(ctx); err != nil {
-		return errors.Wrap(err, "Error connecting to MongoDB instance")
+		return errors.Wrap(translateError(err), "Error connecting to MongoDB instance")
 	}
 
 	// Ping the MongoDB server
 	if err := db.client.Ping(ctx, readpref.Primary()); err != nil {
-		return errors.Wrap(err, "Error pinging the MongoDB instance")
+		return errors.Wrap(translateError(err), "Error pinging the MongoDB instance")
+	}
+
+	// Find out whether transactions and change streams are available
+	replicated, err := isReplicated(ctx, db.client)
+	if err != nil {
+		return errors.Wrap(translateError(err), "Error inspecting the MongoDB deployment")
+	}
+	db.replicated = replicated
+
+	// Reconcile the indexes of the blog and revisions collections
+	if err := syncIndexes(ctx, db.collection, blogIndexes, db.Options.Indexes); err != nil {
+		return err
+	}
+	if err := syncIndexes(ctx, db.revisions, revisionIndexes, db.Options.Indexes); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// isReplicated reports whether the deployment is a replica set or
+// sharded cluster. Standalone servers support neither transactions
+// nor change streams.
+func isReplicated(ctx context.Context, client *mongo.Client) (bool, error) {
+	var res struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	cmd := bson.D{{Key: "isMaster", Value: 1}}
+	if err := client.Database("admin").RunCommand(ctx, cmd).Decode(&res); err != nil {
+		return false, err
+	}
+
+	// Replica set members report their set, and mongos identifies itself
+	return res.SetName != "" || res.Msg == "isdbgrid", nil
+}
+
 // Disconnect disconnects from the MongoDatabase.
 //
 // This function should be called during takedown of services.
@@ -87,133 +165,422 @@ func (db *MongoDatabase) Disconnect(ctx context.Context) error {
 	return nil
 }
 
+// Ping checks that the primary of the MongoDatabase can be reached.
+func (db *MongoDatabase) Ping(ctx context.Context) error {
+	if err := db.client.Ping(ctx, readpref.Primary()); err != nil {
+		return errors.Wrap(translateError(err), "Error pinging the MongoDB instance")
+	}
+
+	return nil
+}
+
 // CreateBlog creates a blog in the database
 func (db *MongoDatabase) CreateBlog(ctx context.Context, blog *blogpb.Blog) (*blogpb.Blog, error) {
-	data := blogItem{
-		AuthorID: blog.GetAuthorId(),
-		Title:    blog.GetTitle(),
-		Content:  blog.GetContent(),
+	now := database.Now()
+	data := &blogItem{
+		AuthorID:   blog.GetAuthorId(),
+		Title:      blog.GetTitle(),
+		Content:    blog.GetContent(),
+		Version:    1,
+		CreateTime: now,
+		UpdateTime: now,
 	}
 
 	res, err := db.collection.InsertOne(ctx, data)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	oid, ok := res.InsertedID.(primitive.ObjectID)
 	if !ok {
 		return nil, errOidConvert
 	}
+	data.ID = oid
 
-	return &blogpb.Blog{
-		Id:       oid.Hex(),
-		AuthorId: blog.GetAuthorId(),
-		Title:    blog.GetTitle(),
-		Content:  blog.GetContent(),
-	}, nil
+	return data.blog(), nil
 }
 
 // ReadBlog reads a user from the database
 func (db *MongoDatabase) ReadBlog(ctx context.Context, id string) (*blogpb.Blog, error) {
-	oid, err := primitive.ObjectIDFromHex(id)
+	oid, err := database.ParseID(id)
 	if err != nil {
 		return nil, err
 	}
 
 	data := &blogItem{}
-	filter := bson.M{"_id": oid}
+	filter := liveFilter(oid)
 
 	doc := db.collection.FindOne(ctx, filter)
 	if err := doc.Decode(data); err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
-	return &blogpb.Blog{
-		Id:       id,
-		AuthorId: data.AuthorID,
-		Title:    data.Title,
-		Content:  data.Content,
-	}, nil
+	return data.blog(), nil
 }
 
-// UpdateBlog updates a blog in the database.
-func (db *MongoDatabase) UpdateBlog(ctx context.Context, blog *blogpb.Blog) (blogpb.UpdateBlogResponse_UpdateStatus, error) {
-	id := blog.GetId()
-	oid, err := primitive.ObjectIDFromHex(id)
+// UpdateBlog updates the given fields of a blog in the database,
+// leaving the other fields unchanged, after recording the replaced
+// version in the revisions collection.
+//
+// The update only applies to the version which was recorded, so a
+// concurrent write makes it retry or, if expectedVersion is set,
+// fail with ErrVersionMismatch.
+func (db *MongoDatabase) UpdateBlog(ctx context.Context, blog *blogpb.Blog, fields []database.Field, expectedVersion int64) (*blogpb.Blog, error) {
+	oid, err := database.ParseID(blog.GetId())
 	if err != nil {
-		return blogpb.UpdateBlogResponse_NOT_UPDATED, err
+		return nil, err
 	}
 
-	data := &blogItem{}
-	filter := bson.M{"_id": oid}
+	for attempt := 1; ; attempt++ {
+		current := &blogItem{}
+		if err := db.collection.FindOne(ctx, liveFilter(oid)).Decode(current); err != nil {
+			return nil, translateError(err)
+		}
+		if expectedVersion != 0 && current.Version != expectedVersion {
+			return nil, database.ErrVersionMismatch
+		}
 
-	// Get the old doc from the DB
-	doc := db.collection.FindOne(ctx, filter)
-	if err := doc.Decode(data); err != nil {
-		return blogpb.UpdateBlogResponse_NOT_UPDATED, err
+		now := database.Now()
+		if err := db.recordRevision(ctx, current, now); err != nil {
+			return nil, err
+		}
+
+		// Documents written before blogs had versions have none
+		filter := liveFilter(oid)
+		filter["version"] = current.Version
+		if current.Version == 0 {
+			filter["version"] = nil
+		}
+
+		data := &blogItem{}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		res := db.collection.FindOneAndUpdate(ctx, filter, updateDocument(blog, fields, now), opts)
+		err := res.Decode(data)
+		if err == nil {
+			return data.blog(), nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, translateError(err)
+		}
+
+		// The blog was deleted or replaced since it was read
+		err = db.missingError(ctx, liveFilter(oid))
+		if err != database.ErrVersionMismatch || expectedVersion != 0 || attempt == maxUpdateAttempts {
+			return nil, err
+		}
 	}
+}
 
-	// Update variables on the document
-	data.AuthorID = blog.GetAuthorId()
-	data.Title = blog.GetTitle()
-	data.Content = blog.GetContent()
+// updateDocument returns the MongoDB update for the given fields
+// of a blog, which also increments its version and sets its update
+// time. Empty strings are unset rather than stored, in the same way
+// as the omitempty fields of a blogItem.
+func updateDocument(blog *blogpb.Blog, fields []database.Field, now time.Time) bson.M {
+	set := bson.M{"update_time": now}
+	unset := bson.M{}
+	for _, field := range fields {
+		var value string
+		switch field {
+		case database.FieldAuthorID:
+			value = blog.GetAuthorId()
+		case database.FieldTitle:
+			value = blog.GetTitle()
+		case database.FieldContent:
+			value = blog.GetContent()
+		default:
+			continue
+		}
 
-	_, err = db.collection.ReplaceOne(ctx, filter, data)
-	if err != nil {
-		return blogpb.UpdateBlogResponse_NOT_UPDATED, err
+		// The fields are stored under their proto names
+		if value == "" {
+			unset[string(field)] = ""
+		} else {
+			set[string(field)] = value
+		}
 	}
 
-	return blogpb.UpdateBlogResponse_UPDATED, nil
+	update := bson.M{
+		"$inc": bson.M{"version": 1},
+		"$set": set,
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
 }
 
-// DeleteBlog deletes a blog from the database
-func (db *MongoDatabase) DeleteBlog(ctx context.Context, id string) (blogpb.DeleteBlogResponse_DeleteStatus, error) {
-	oid, err := primitive.ObjectIDFromHex(id)
+// CheckTimes checks that every blog has create and update times,
+// which the time filters and orders of ListBlogs rely on. Blogs
+// written before blogs had timestamps are only given them by
+// migration 2, so it fails until the migrations have been applied.
+func (db *MongoDatabase) CheckTimes(ctx context.Context) error {
+	// Times are never null, so these match only missing times
+	filter := bson.M{"$or": bson.A{
+		bson.M{"create_time": nil},
+		bson.M{"update_time": nil},
+	}}
+	n, err := db.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
 	if err != nil {
-		return blogpb.DeleteBlogResponse_NOT_DELETED, err
+		return translateError(err)
 	}
+	if n > 0 {
+		return errors.New("Some blogs have no create or update time; apply the database migrations with --migrate or the migrate subcommand")
+	}
+	return nil
+}
 
-	filter := bson.M{"_id": oid}
-
-	res, err := db.collection.DeleteOne(ctx, filter)
+// missingError explains why a write filtered by version matched no
+// blog, given a filter which matches the blog regardless of version:
+// ErrVersionMismatch if the blog exists, or ErrNotFound if it does not.
+func (db *MongoDatabase) missingError(ctx context.Context, filter bson.M) error {
+	n, err := db.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
 	if err != nil {
-		return blogpb.DeleteBlogResponse_NOT_DELETED, err
+		return translateError(err)
 	}
-
-	if res.DeletedCount == 0 {
-		return blogpb.DeleteBlogResponse_NOT_DELETED, nil
+	if n > 0 {
+		return database.ErrVersionMismatch
 	}
-
-	return blogpb.DeleteBlogResponse_DELETED, nil
+	return database.ErrNotFound
 }
 
-// ListBlogs lists all the blogs in the database.
-func (db *MongoDatabase) ListBlogs(stream blogpb.BlogService_ListBlogsServer) error {
-	cur, err := db.collection.Find(context.Background(), bson.D{})
+// ListBlogs lists the blogs in the database in the requested order, calling fn for each one.
+//
+// The cursor is closed as soon as fn returns an error or ctx is cancelled.
+func (db *MongoDatabase) ListBlogs(ctx context.Context, opts *database.ListOptions, fn func(*blogpb.Blog) error) error {
+	if opts == nil {
+		opts = &database.ListOptions{}
+	}
+
+	filter, err := listFilter(opts)
 	if err != nil {
 		return err
 	}
-	defer cur.Close(context.Background())
 
-	for cur.Next(context.Background()) {
+	findOpts := options.Find().SetSort(listSort(opts.Sort))
+	if opts.Limit > 0 {
+		findOpts.SetLimit(int64(opts.Limit))
+	}
+
+	cur, err := db.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return translateError(err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
 		data := &blogItem{}
 		if err := cur.Decode(data); err != nil {
+			return translateError(err)
+		}
+
+		if err := fn(data.blog()); err != nil {
 			return err
 		}
+	}
+
+	if err := cur.Err(); err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// sortKeys maps the sortable fields to their MongoDB keys.
+var sortKeys = map[database.SortField]string{
+	database.SortByID:         "_id",
+	database.SortByAuthorID:   "author_id",
+	database.SortByTitle:      "title",
+	database.SortByCreateTime: "create_time",
+	database.SortByUpdateTime: "update_time",
+}
+
+// listSort translates a sort order to a MongoDB sort document.
+func listSort(sort database.Sort) bson.D {
+	dir := 1
+	if sort.Descending {
+		dir = -1
+	}
+
+	key, ok := sortKeys[sort.Field]
+	if !ok || key == "_id" {
+		return bson.D{{Key: "_id", Value: dir}}
+	}
+
+	// Break ties by _id so that the order is total
+	return bson.D{{Key: key, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+// listFilter translates the options for ListBlogs to a MongoDB query.
+func listFilter(opts *database.ListOptions) (bson.M, error) {
+	var conds []bson.M
+	f := opts.Filter
+
+	// A missing or null delete_time marks a blog which is not in the trash
+	if opts.Deleted {
+		conds = append(conds, bson.M{"delete_time": bson.M{"$ne": nil}})
+	} else {
+		conds = append(conds, bson.M{"delete_time": nil})
+	}
+
+	// Collect the range conditions on _id in a single document
+	idFilter := bson.M{}
+	for op, id := range map[string]string{
+		"$gte": f.MinID,
+		"$lt":  f.MaxID,
+	} {
+		if id == "" {
+			continue
+		}
+		oid, err := database.ParseID(id)
+		if err != nil {
+			return nil, err
+		}
+		idFilter[op] = oid
+	}
+	if len(idFilter) > 0 {
+		conds = append(conds, bson.M{"_id": idFilter})
+	}
+
+	if f.AuthorID != "" {
+		conds = append(conds, bson.M{"author_id": f.AuthorID})
+	}
+
+	conds = append(conds, timeRange("create_time", f.MinCreateTime, f.MaxCreateTime)...)
+	conds = append(conds, timeRange("update_time", f.MinUpdateTime, f.MaxUpdateTime)...)
+
+	// A prefix regex can use an index on title, while a
+	// case-insensitive substring match requires a scan.
+	if f.TitlePrefix != "" {
+		conds = append(conds, bson.M{"title": primitive.Regex{
+			Pattern: "^" + regexp.QuoteMeta(f.TitlePrefix),
+		}})
+	}
+	if f.TitleContains != "" {
+		conds = append(conds, bson.M{"title": primitive.Regex{
+			Pattern: regexp.QuoteMeta(f.TitleContains),
+			Options: "i",
+		}})
+	}
+
+	if opts.After != nil {
+		cond, err := cursorFilter(opts.Sort, opts.After)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+
+	return bson.M{"$and": conds}, nil
+}
+
+// timeRange returns the conditions for a time field to be at or after
+// min and before max, where a zero min or max is unbounded.
+func timeRange(key string, min, max time.Time) []bson.M {
+	cond := bson.M{}
+	if !min.IsZero() {
+		cond["$gte"] = min
+	}
+	if !max.IsZero() {
+		cond["$lt"] = max
+	}
+	if len(cond) == 0 {
+		return nil
+	}
+	return []bson.M{{key: cond}}
+}
+
+// cursorFilter returns a query matching the blogs which
+// come after the cursor in the given sort order.
+func cursorFilter(sort database.Sort, after *database.Cursor) (bson.M, error) {
+	oid, err := database.ParseID(after.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	op := "$gt"
+	if sort.Descending {
+		op = "$lt"
+	}
+
+	key, ok := sortKeys[sort.Field]
+	if !ok || key == "_id" {
+		return bson.M{"_id": bson.M{op: oid}}, nil
+	}
+
+	// The server only starts once every blog has its times (see
+	// CheckTimes), so there are no missing values
+	if database.IsTimeField(sort.Field) {
+		t, err := database.ParseCursorTime(after.Value)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$or": bson.A{
+			bson.M{key: t, "_id": bson.M{op: oid}},
+			bson.M{key: bson.M{op: t}},
+		}}, nil
+	}
+
+	// Empty strings are not stored, so a missing field sorts
+	// first and is treated the same as an empty string.
+	empty := bson.M{"$in": bson.A{nil, ""}}
+
+	var value interface{} = after.Value
+	if after.Value == "" {
+		value = empty
+	}
+	conds := []bson.M{{key: value, "_id": bson.M{op: oid}}}
+
+	switch {
+	case !sort.Descending:
+		conds = append(conds, bson.M{key: bson.M{"$gt": after.Value}})
+	case after.Value != "":
+		conds = append(conds, bson.M{key: bson.M{"$lt": after.Value}}, bson.M{key: empty})
+	}
+
+	return bson.M{"$or": conds}, nil
+}
+
+// A blog item along with its text search score
+type searchItem struct {
+	blogItem `bson:",inline"`
+	Score    float64 `bson:"score"`
+}
+
+// SearchBlogs searches the title and content of the blogs in the database,
+// returning at most limit results, most relevant first.
+func (db *MongoDatabase) SearchBlogs(ctx context.Context, query string, limit int) ([]*database.SearchResult, error) {
+	filter := bson.M{"$text": bson.M{"$search": query}, "delete_time": nil}
+	score := bson.M{"$meta": "textScore"}
+
+	findOpts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		findOpts.SetLimit(int64(limit))
+	}
 
-		blog := &blogpb.Blog{
-			Id:       data.ID.Hex(),
-			AuthorId: data.AuthorID,
-			Title:    data.Title,
-			Content:  data.Content,
+	cur, err := db.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer cur.Close(ctx)
+
+	var results []*database.SearchResult
+	for cur.Next(ctx) {
+		data := &searchItem{}
+		if err := cur.Decode(data); err != nil {
+			return nil, translateError(err)
 		}
 
-		stream.Send(&blogpb.ListBlogsResponse{Blog: blog})
+		results = append(results, &database.SearchResult{
+			Blog:  data.blog(),
+			Score: data.Score,
+		})
 	}
 
 	if err := cur.Err(); err != nil {
-		return err
+		return nil, translateError(err)
 	}
 
-	return nil
+	return results, nil
 }
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// The database used when none is specified
+	defaultDatabase = "mydb"
+	// The blog collection used when none is specified
+	defaultCollection = "blog"
+)
+
+// MongoDatabaseOptions specifies the options for
+// connecting to a MongoDatabase.
+//
+// The deployment is located either by a full connection URI
+// or by Host and Port. The remaining fields are applied on top
+// of the URI, overriding any settings it contains.
+type MongoDatabaseOptions struct {
+	// A connection string such as mongodb://host1,host2/?replicaSet=rs0
+	// or mongodb+srv://cluster.example.com. Host and Port are
+	// ignored when it is set.
+	URI  string
+	Host string
+	Port int
+
+	// Credentials for authenticating with the deployment
+	Username   string
+	Password   string
+	AuthSource string
+
+	// Whether to connect over TLS. TLS is also enabled
+	// when either of the TLS files is set.
+	TLS bool
+	// A PEM file with the CA certificates used to verify the server
+	TLSCAFile string
+	// A PEM file with the client certificate and its private key
+	TLSCertificateKeyFile string
+
+	// The name of the replica set to connect to
+	ReplicaSet string
+
+	// The database and collection holding the blogs.
+	// They default to "mydb" and "blog". The revisions
+	// of the blogs are kept in the collection of the same
+	// name with a "_revisions" suffix.
+	Database   string
+	Collection string
+
+	// How indexes are reconciled on Connect
+	Indexes IndexOptions
+}
+
+// uri returns the connection URI, including any credentials it contains.
+func (opts *MongoDatabaseOptions) uri() string {
+	if opts.URI != "" {
+		return opts.URI
+	}
+	return fmt.Sprintf("mongodb://%s:%d", opts.Host, opts.Port)
+}
+
+func (opts *MongoDatabaseOptions) databaseName() string {
+	if opts.Database != "" {
+		return opts.Database
+	}
+	return defaultDatabase
+}
+
+func (opts *MongoDatabaseOptions) collectionName() string {
+	if opts.Collection != "" {
+		return opts.Collection
+	}
+	return defaultCollection
+}
+
+func (opts *MongoDatabaseOptions) revisionsCollectionName() string {
+	return opts.collectionName() + "_revisions"
+}
+
+// clientOptions builds the MongoDB client options.
+func (opts *MongoDatabaseOptions) clientOptions() (*options.ClientOptions, error) {
+	clientOpts := options.Client().ApplyURI(opts.uri())
+	if err := clientOpts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid MongoDB connection URI")
+	}
+
+	if opts.Username != "" {
+		cred := options.Credential{
+			Username:   opts.Username,
+			Password:   opts.Password,
+			AuthSource: opts.AuthSource,
+		}
+		// Keep the mechanism from the URI, if any
+		if clientOpts.Auth != nil {
+			cred.AuthMechanism = clientOpts.Auth.AuthMechanism
+			cred.AuthMechanismProperties = clientOpts.Auth.AuthMechanismProperties
+		}
+		clientOpts.SetAuth(cred)
+	} else if opts.AuthSource != "" && clientOpts.Auth != nil {
+		clientOpts.Auth.AuthSource = opts.AuthSource
+	}
+
+	if opts.TLS || opts.TLSCAFile != "" || opts.TLSCertificateKeyFile != "" {
+		tlsConfig, err := opts.tlsConfig(clientOpts.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts.SetTLSConfig(tlsConfig)
+	}
+
+	if opts.ReplicaSet != "" {
+		clientOpts.SetReplicaSet(opts.ReplicaSet)
+	}
+
+	return clientOpts, nil
+}
+
+// tlsConfig builds the TLS configuration from the TLS files, on top
+// of base, the configuration parsed from the URI, if it has one. The
+// files replace the CA certificates or client certificate of the URI,
+// and its other settings, such as tlsInsecure, are kept.
+func (opts *MongoDatabaseOptions) tlsConfig(base *tls.Config) (*tls.Config, error) {
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+
+	if opts.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading TLS CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("No certificates found in TLS CA file %s", opts.TLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.TLSCertificateKeyFile != "" {
+		// The certificate and key are stored in the same file
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertificateKeyFile, opts.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error loading TLS client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// The mask which replaces secrets in a redacted URI
+const redacted = "xxxxx"
+
+// secretOptions are the URI options, in lowercase as options are
+// case-insensitive, whose values are secrets.
+var secretOptions = map[string]bool{
+	"tlscertificatekeyfilepassword":   true,
+	"sslclientcertificatekeypassword": true,
+}
+
+// secretProperties are the authentication mechanism
+// properties, in uppercase, whose values are secrets.
+var secretProperties = map[string]bool{
+	"AWS_SESSION_TOKEN": true,
+}
+
+// redact replaces the secrets in a connection URI: the password,
+// if any, and the values of secret options and authentication
+// mechanism properties.
+func redact(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		// Without knowing where the secrets are, hide everything
+		return "<unparseable URI>"
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+
+	// Rewrite the query by hand, keeping the order of the options
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		eq := strings.Index(param, "=")
+		if eq < 0 {
+			continue
+		}
+		key, value := param[:eq], param[eq+1:]
+		switch strings.ToLower(key) {
+		case "authmechanismproperties":
+			params[i] = key + "=" + redactProperties(value)
+		default:
+			if secretOptions[strings.ToLower(key)] {
+				params[i] = key + "=" + redacted
+			}
+		}
+	}
+	u.RawQuery = strings.Join(params, "&")
+
+	return u.String()
+}
+
+// redactProperties replaces the secret values in the raw, comma-separated
+// name:value pairs of the authMechanismProperties option.
+func redactProperties(raw string) string {
+	value, err := url.QueryUnescape(raw)
+	if err != nil {
+		return redacted
+	}
+
+	props := strings.Split(value, ",")
+	for i, prop := range props {
+		colon := strings.Index(prop, ":")
+		if colon >= 0 && secretProperties[strings.ToUpper(prop[:colon])] {
+			props[i] = prop[:colon+1] + redacted
+		}
+	}
+	return strings.Join(props, ",")
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A mapping of a blog revision to MongoDB types
+type revisionItem struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID      primitive.ObjectID `bson:"blog_id"`
+	Version     int64              `bson:"version"`
+	AuthorID    string             `bson:"author_id,omitempty"`
+	Title       string             `bson:"title,omitempty"`
+	Content     string             `bson:"content,omitempty"`
+	CreateTime  time.Time          `bson:"create_time,omitempty"`
+	UpdateTime  time.Time          `bson:"update_time,omitempty"`
+	ReplaceTime time.Time          `bson:"replace_time"`
+}
+
+// revision converts a revision item to a BlogRevision.
+func (item *revisionItem) revision() *blogpb.BlogRevision {
+	blog := &blogItem{
+		ID:         item.BlogID,
+		AuthorID:   item.AuthorID,
+		Title:      item.Title,
+		Content:    item.Content,
+		Version:    item.Version,
+		CreateTime: item.CreateTime,
+		UpdateTime: item.UpdateTime,
+	}
+
+	return &blogpb.BlogRevision{
+		Blog:        blog.blog(),
+		ReplaceTime: database.Timestamp(item.ReplaceTime),
+	}
+}
+
+// recordRevision records a version of a blog which is about
+// to be replaced. A version which was already recorded by an
+// earlier attempt is left unchanged.
+func (db *MongoDatabase) recordRevision(ctx context.Context, blog *blogItem, replaced time.Time) error {
+	data := &revisionItem{
+		BlogID:      blog.ID,
+		Version:     blog.Version,
+		AuthorID:    blog.AuthorID,
+		Title:       blog.Title,
+		Content:     blog.Content,
+		CreateTime:  blog.CreateTime,
+		UpdateTime:  blog.UpdateTime,
+		ReplaceTime: replaced,
+	}
+
+	if _, err := db.revisions.InsertOne(ctx, data); err != nil {
+		if err = translateError(err); errors.Is(err, database.ErrConflict) {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ListBlogRevisions lists the revisions of a blog, newest first.
+func (db *MongoDatabase) ListBlogRevisions(ctx context.Context, id string, before int64, limit int) ([]*blogpb.BlogRevision, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"blog_id": oid}
+	if before > 0 {
+		filter["version"] = bson.M{"$lt": before}
+	}
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	if limit > 0 {
+		findOpts.SetLimit(int64(limit))
+	}
+
+	cur, err := db.revisions.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer cur.Close(ctx)
+
+	var revisions []*blogpb.BlogRevision
+	for cur.Next(ctx) {
+		data := &revisionItem{}
+		if err := cur.Decode(data); err != nil {
+			return nil, translateError(err)
+		}
+		revisions = append(revisions, data.revision())
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	if err := db.checkRevisions(ctx, oid); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// GetBlogRevision gets the revision of a blog at a version.
+func (db *MongoDatabase) GetBlogRevision(ctx context.Context, id string, version int64) (*blogpb.BlogRevision, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &revisionItem{}
+	filter := bson.M{"blog_id": oid, "version": version}
+	findErr := translateError(db.revisions.FindOne(ctx, filter).Decode(data))
+	if findErr != nil && !errors.Is(findErr, database.ErrNotFound) {
+		return nil, findErr
+	}
+
+	// A missing revision of a blog in the trash is reported as ErrDeleted
+	if err := db.checkRevisions(ctx, oid); err != nil {
+		return nil, err
+	}
+	if findErr != nil {
+		return nil, findErr
+	}
+
+	return data.revision(), nil
+}
+
+// checkRevisions checks that the revisions of a blog may be read,
+// which is only while it is live. It is called after the revisions
+// are read, so a blog which is deleted or purged in the meantime
+// is reported as such rather than having its revisions returned.
+func (db *MongoDatabase) checkRevisions(ctx context.Context, oid primitive.ObjectID) error {
+	data := &blogItem{}
+	findOpts := options.FindOne().SetProjection(bson.M{"delete_time": 1})
+	if err := db.collection.FindOne(ctx, bson.M{"_id": oid}, findOpts).Decode(data); err != nil {
+		return translateError(err)
+	}
+	if !data.DeleteTime.IsZero() {
+		return database.ErrDeleted
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How many expired blogs PurgeDeletedBlogs removes at a time
+const purgeBatchSize = 1000
+
+// DeleteBlog moves a blog to the trash by setting its delete time.
+func (db *MongoDatabase) DeleteBlog(ctx context.Context, id string, expectedVersion int64) (blogpb.DeleteBlogResponse_DeleteStatus, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, err
+	}
+
+	filter := liveFilter(oid)
+	if expectedVersion != 0 {
+		filter["version"] = expectedVersion
+	}
+	update := bson.M{"$set": bson.M{"delete_time": database.Now()}}
+
+	res, err := db.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, translateError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		if expectedVersion != 0 {
+			return blogpb.DeleteBlogResponse_NOT_DELETED, db.missingError(ctx, liveFilter(oid))
+		}
+		return blogpb.DeleteBlogResponse_NOT_DELETED, database.ErrNotFound
+	}
+
+	return blogpb.DeleteBlogResponse_DELETED, nil
+}
+
+// PurgeBlog removes a blog and its revisions from the database.
+func (db *MongoDatabase) PurgeBlog(ctx context.Context, id string, expectedVersion int64) (blogpb.DeleteBlogResponse_DeleteStatus, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, err
+	}
+
+	filter := bson.M{"_id": oid}
+	if expectedVersion != 0 {
+		filter["version"] = expectedVersion
+	}
+
+	res, err := db.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return blogpb.DeleteBlogResponse_NOT_DELETED, translateError(err)
+	}
+
+	if res.DeletedCount == 0 {
+		if expectedVersion != 0 {
+			return blogpb.DeleteBlogResponse_NOT_DELETED, db.missingError(ctx, bson.M{"_id": oid})
+		}
+		return blogpb.DeleteBlogResponse_NOT_DELETED, database.ErrNotFound
+	}
+
+	// The blog is gone either way, so a failure to delete
+	// its revisions leaves orphans but is not an error
+	if _, err := db.revisions.DeleteMany(ctx, bson.M{"blog_id": oid}); err != nil {
+		log.Printf("Error deleting the revisions of blog %s: %v", id, err)
+	}
+
+	return blogpb.DeleteBlogResponse_DELETED, nil
+}
+
+// UndeleteBlog restores a blog from the trash.
+func (db *MongoDatabase) UndeleteBlog(ctx context.Context, id string) (*blogpb.Blog, error) {
+	oid, err := database.ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": oid, "delete_time": bson.M{"$ne": nil}}
+	update := bson.M{"$unset": bson.M{"delete_time": ""}}
+
+	data := &blogItem{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := db.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(data); err != nil {
+		return nil, translateError(err)
+	}
+
+	return data.blog(), nil
+}
+
+// PurgeDeletedBlogs removes the blogs which were moved to the trash
+// before the given time, along with their revisions, in batches.
+func (db *MongoDatabase) PurgeDeletedBlogs(ctx context.Context, before time.Time) (int, error) {
+	filter := bson.M{"delete_time": bson.M{"$lt": before}}
+	findOpts := options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetLimit(purgeBatchSize)
+
+	purged := 0
+	for {
+		cur, err := db.collection.Find(ctx, filter, findOpts)
+		if err != nil {
+			return purged, translateError(err)
+		}
+
+		var items []blogItem
+		if err := cur.All(ctx, &items); err != nil {
+			return purged, translateError(err)
+		}
+		if len(items) == 0 {
+			return purged, nil
+		}
+
+		ids := make(bson.A, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+		}
+
+		// A blog restored since it was found is left alone
+		res, err := db.collection.DeleteMany(ctx, bson.M{
+			"_id":         bson.M{"$in": ids},
+			"delete_time": bson.M{"$lt": before},
+		})
+		if err != nil {
+			return purged, translateError(err)
+		}
+		purged += int(res.DeletedCount)
+
+		if err := db.purgeRevisions(ctx, ids); err != nil {
+			return purged, err
+		}
+
+		if len(items) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// purgeRevisions removes the revisions of those of the given
+// blogs which no longer exist.
+func (db *MongoDatabase) purgeRevisions(ctx context.Context, ids bson.A) error {
+	cur, err := db.collection.Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return translateError(err)
+	}
+	var remaining []blogItem
+	if err := cur.All(ctx, &remaining); err != nil {
+		return translateError(err)
+	}
+
+	filter := bson.M{"blog_id": bson.M{"$in": ids}}
+	if len(remaining) > 0 {
+		kept := make(bson.A, len(remaining))
+		for i, item := range remaining {
+			kept[i] = item.ID
+		}
+		filter = bson.M{"$and": bson.A{filter, bson.M{"blog_id": bson.M{"$nin": kept}}}}
+	}
+
+	if _, err := db.revisions.DeleteMany(ctx, filter); err != nil {
+		return translateError(err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server error codes for resume tokens which cannot be used
+const (
+	invalidResumeTokenCode      = 260
+	changeStreamFatalErrorCode  = 280
+	changeStreamHistoryLostCode = 286
+)
+
+// changeEvent is the part of a change stream event used by WatchBlogs.
+type changeEvent struct {
+	OperationType string    `bson:"operationType"`
+	FullDocument  *blogItem `bson:"fullDocument"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// WatchBlogs watches the changes to blogs with a change stream, which
+// needs a replica set or sharded cluster. Updates are reported with
+// the blog as it is when the event is read, so an update followed by
+// a delete is only reported as the delete.
+func (db *MongoDatabase) WatchBlogs(ctx context.Context, opts *database.WatchOptions, fn func(*database.Change) error) error {
+	if !db.replicated {
+		return errors.Wrap(database.ErrUnsupported, "Change streams need a replica set")
+	}
+	if opts == nil {
+		opts = &database.WatchOptions{}
+	}
+
+	match := bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}
+	if opts.AuthorID != "" {
+		// Deletes do not carry the document, so they match any author
+		match["$or"] = bson.A{
+			bson.M{"fullDocument.author_id": opts.AuthorID},
+			bson.M{"operationType": "delete"},
+		}
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+
+	csOpts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if opts.ResumeToken != "" {
+		token, err := decodeResumeToken(opts.ResumeToken)
+		if err != nil {
+			return err
+		}
+		csOpts.SetResumeAfter(token)
+	}
+
+	cs, err := db.collection.Watch(ctx, pipeline, csOpts)
+	if err != nil {
+		return watchError(err)
+	}
+	defer cs.Close(context.Background())
+
+	for cs.Next(ctx) {
+		ev := &changeEvent{}
+		if err := cs.Decode(ev); err != nil {
+			return errors.Wrap(err, "Error decoding change event")
+		}
+
+		change := ev.change()
+		if change == nil {
+			continue
+		}
+		change.ResumeToken = base64.RawURLEncoding.EncodeToString(cs.ResumeToken())
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return watchError(cs.Err())
+}
+
+// change converts a change stream event to a Change, or returns
+// nil if it should not be reported.
+func (ev *changeEvent) change() *database.Change {
+	if ev.OperationType == "delete" {
+		return &database.Change{
+			Type: database.Deleted,
+			Blog: &blogpb.Blog{Id: ev.DocumentKey.ID.Hex()},
+		}
+	}
+
+	// The blog was removed before its update was read, which
+	// is reported by a later event
+	data := ev.FullDocument
+	if data == nil {
+		return nil
+	}
+
+	typ := database.Updated
+	_, deleted := ev.UpdateDescription.UpdatedFields["delete_time"]
+	switch {
+	case ev.OperationType == "insert":
+		typ = database.Created
+	case deleted && !data.DeleteTime.IsZero():
+		typ = database.Deleted
+	case !data.DeleteTime.IsZero():
+		// Changes to blogs in the trash are not reported
+		return nil
+	case contains(ev.UpdateDescription.RemovedFields, "delete_time"):
+		typ = database.Created
+	}
+
+	return &database.Change{Type: typ, Blog: data.blog()}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeResumeToken decodes a resume token returned by WatchBlogs.
+func decodeResumeToken(token string) (bson.Raw, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, database.ErrInvalidResumeToken
+	}
+	raw := bson.Raw(b)
+	if err := raw.Validate(); err != nil {
+		return nil, database.ErrInvalidResumeToken
+	}
+	return raw, nil
+}
+
+// watchError translates the errors of a change stream, recognizing
+// resume tokens which the server cannot use.
+func watchError(err error) error {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		switch ce.Code {
+		case invalidResumeTokenCode:
+			return fmt.Errorf("%w: %v", database.ErrInvalidResumeToken, err)
+		case changeStreamFatalErrorCode, changeStreamHistoryLostCode:
+			return fmt.Errorf("%w: %v", database.ErrResumeTokenExpired, err)
+		}
+	}
+	return translateError(err)
+}
@@ -0,0 +1,151 @@
+package resilience
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// The states of a circuit breaker
+const (
+	stateClosed   = "closed"
+	stateOpen     = "open"
+	stateHalfOpen = "half-open"
+)
+
+// outcome is how a call which the breaker allowed ended.
+type outcome int
+
+const (
+	succeeded outcome = iota
+	failed
+	// The caller went away, so the call says nothing about the database
+	abandoned
+)
+
+// breaker is a circuit breaker which opens after a number of
+// consecutive failures, rejecting calls until a cooldown has
+// passed. It then lets a single trial call through, which closes
+// it again if it succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// Whether the trial call of the half-open state is in flight
+	trial bool
+	// How many times the breaker has opened
+	opens uint64
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     stateClosed,
+	}
+}
+
+// allow reports whether a call may go ahead, and whether it
+// is the trial call of the half-open state.
+func (b *breaker) allow() (ok, trial bool) {
+	if b.threshold <= 0 {
+		return true, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false, false
+		}
+		b.setState(stateHalfOpen)
+		b.trial = true
+		return true, true
+	case stateHalfOpen:
+		// Only the trial call goes ahead
+		if b.trial {
+			return false, false
+		}
+		b.trial = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// record records the outcome of a call which was allowed, where
+// trial is what allow returned for it. Only the trial call decides
+// whether the half-open breaker closes or opens again, and an
+// abandoned trial leaves it half-open for the next call to try.
+// Calls which were allowed before the breaker opened, and finish
+// after, are ignored.
+func (b *breaker) record(trial bool, o outcome) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+		switch o {
+		case failed:
+			b.open()
+		case succeeded:
+			b.failures = 0
+			b.setState(stateClosed)
+		}
+		return
+	}
+	if b.state != stateClosed {
+		return
+	}
+
+	switch o {
+	case abandoned:
+		return
+	case succeeded:
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+// open opens the breaker. It must be called with b.mu held.
+func (b *breaker) open() {
+	b.openedAt = time.Now()
+	b.opens++
+	b.setState(stateOpen)
+}
+
+// setState changes the state of the breaker, logging the change.
+// It must be called with b.mu held.
+func (b *breaker) setState(state string) {
+	if state == b.state {
+		return
+	}
+	switch state {
+	case stateOpen:
+		log.Printf("Circuit breaker opened after %d consecutive failures; rejecting calls for %v", b.failures, b.cooldown)
+	default:
+		log.Printf("Circuit breaker %s", state)
+	}
+	b.state = state
+}
+
+// status returns the state of the breaker and how many times it has opened.
+func (b *breaker) status() (string, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.opens
+}
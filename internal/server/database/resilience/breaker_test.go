@@ -0,0 +1,69 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerIgnoresLateResults(t *testing.T) {
+	b := newBreaker(1, 10*time.Millisecond)
+
+	// A slow call is allowed while the breaker is closed
+	if ok, trial := b.allow(); !ok || trial {
+		t.Fatalf("allow() = %t, %t, want true, false", ok, trial)
+	}
+
+	// Another call fails, opening the breaker
+	b.allow()
+	b.record(false, failed)
+	if state, _ := b.status(); state != stateOpen {
+		t.Fatalf("State is %s, want %s", state, stateOpen)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	ok, trial := b.allow()
+	if !ok || !trial {
+		t.Fatalf("allow() = %t, %t, want true, true", ok, trial)
+	}
+
+	// The slow call succeeds after the trial has started
+	b.record(false, succeeded)
+	if state, _ := b.status(); state != stateHalfOpen {
+		t.Fatalf("State is %s after a late success, want %s", state, stateHalfOpen)
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatalf("A second call was allowed while the trial was in flight")
+	}
+
+	// The trial decides the state
+	b.record(true, failed)
+	if state, opens := b.status(); state != stateOpen || opens != 2 {
+		t.Fatalf("State is %s after %d opens, want %s after 2", state, opens, stateOpen)
+	}
+}
+
+func TestBreakerAbandonedTrial(t *testing.T) {
+	b := newBreaker(1, 10*time.Millisecond)
+	b.allow()
+	b.record(false, failed)
+
+	time.Sleep(20 * time.Millisecond)
+	if ok, trial := b.allow(); !ok || !trial {
+		t.Fatalf("allow() = %t, %t, want true, true", ok, trial)
+	}
+
+	// The caller of the trial goes away
+	b.record(true, abandoned)
+	if state, _ := b.status(); state != stateHalfOpen {
+		t.Fatalf("State is %s after an abandoned trial, want %s", state, stateHalfOpen)
+	}
+
+	// The next call becomes the trial
+	if ok, trial := b.allow(); !ok || !trial {
+		t.Fatalf("allow() = %t, %t, want true, true", ok, trial)
+	}
+	b.record(true, succeeded)
+	if state, _ := b.status(); state != stateClosed {
+		t.Fatalf("State is %s, want %s", state, stateClosed)
+	}
+}
@@ -0,0 +1,415 @@
+// Package resilience provides a database.Database which adds
+// timeouts, retries and circuit breaking to any other.
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/pkg/errors"
+)
+
+// Options configures a ResilientDatabase.
+type Options struct {
+	// How long a single call to the database may take, or zero
+	// for no limit. Streams and background purges are not limited.
+	Timeout time.Duration
+	// How many times a failed read is retried.
+	MaxRetries int
+	// The delay before the first retry, which doubles with each
+	// further retry up to MaxBackoff. The delays are jittered.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// How many consecutive failures open the circuit breaker,
+	// or zero to never open it.
+	FailureThreshold int
+	// How long the open circuit breaker rejects calls before
+	// letting a trial call through.
+	Cooldown time.Duration
+}
+
+// Stats describes the state of a ResilientDatabase.
+type Stats struct {
+	// The state of the circuit breaker: closed, open or half-open
+	State string `json:"state"`
+	// How many times the circuit breaker has opened
+	Opens uint64 `json:"opens"`
+	// How many calls were rejected by the open circuit breaker
+	Rejected uint64 `json:"rejected"`
+	// How many calls failed because the database was unavailable
+	Failures uint64 `json:"failures"`
+	// How many calls timed out
+	Timeouts uint64 `json:"timeouts"`
+	// How many reads were retried
+	Retries uint64 `json:"retries"`
+}
+
+// ErrCircuitOpen is returned while the circuit breaker is open.
+// It wraps database.ErrUnavailable.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker is open", database.ErrUnavailable)
+
+// ResilientDatabase is a Database which limits how long each call
+// may take, retries reads which fail because the database is
+// unavailable, and stops calling the database for a while once
+// too many calls in a row have failed.
+//
+// Only failures which suggest that the database is unreachable or
+// overloaded count towards the circuit breaker: timeouts and
+// ErrUnavailable. Errors such as ErrNotFound are ordinary outcomes.
+//
+// Every method of the Database interface is wrapped explicitly, so
+// that a method added to the interface must decide how it is
+// protected before this package compiles again.
+type ResilientDatabase struct {
+	db      database.Database
+	opts    Options
+	breaker *breaker
+
+	rejected uint64
+	failures uint64
+	timeouts uint64
+	retries  uint64
+}
+
+var _ database.Database = (*ResilientDatabase)(nil)
+
+// New wraps db with timeouts, retries and a circuit breaker.
+func New(db database.Database, opts Options) *ResilientDatabase {
+	return &ResilientDatabase{
+		db:      db,
+		opts:    opts,
+		breaker: newBreaker(opts.FailureThreshold, opts.Cooldown),
+	}
+}
+
+// Stats returns the state of the circuit breaker and the counts
+// of failed, rejected and retried calls so far.
+func (r *ResilientDatabase) Stats() Stats {
+	state, opens := r.breaker.status()
+	return Stats{
+		State:    state,
+		Opens:    opens,
+		Rejected: atomic.LoadUint64(&r.rejected),
+		Failures: atomic.LoadUint64(&r.failures),
+		Timeouts: atomic.LoadUint64(&r.timeouts),
+		Retries:  atomic.LoadUint64(&r.retries),
+	}
+}
+
+// call makes a single call to the database through the circuit
+// breaker, with the timeout if limit is set.
+func (r *ResilientDatabase) call(ctx context.Context, limit bool, fn func(ctx context.Context) error) error {
+	trial, err := r.allow()
+	if err != nil {
+		return err
+	}
+
+	callCtx := ctx
+	if limit && r.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, r.opts.Timeout)
+		defer cancel()
+	}
+
+	err = fn(callCtx)
+
+	// Only our own deadline is a timeout; the caller's is their business
+	if err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		atomic.AddUint64(&r.timeouts, 1)
+		err = fmt.Errorf("%w: database call timed out after %v", context.DeadlineExceeded, r.opts.Timeout)
+	}
+
+	r.record(ctx, trial, err)
+	return err
+}
+
+// allow asks the circuit breaker whether a call may go ahead,
+// returning ErrCircuitOpen if not, and whether it is the trial call.
+func (r *ResilientDatabase) allow() (trial bool, err error) {
+	ok, trial := r.breaker.allow()
+	if !ok {
+		atomic.AddUint64(&r.rejected, 1)
+		return false, ErrCircuitOpen
+	}
+	return trial, nil
+}
+
+// record records the outcome of a call with the circuit breaker.
+func (r *ResilientDatabase) record(ctx context.Context, trial bool, err error) {
+	switch {
+	case ctx.Err() != nil:
+		r.breaker.record(trial, abandoned)
+	case isFailure(ctx, err):
+		atomic.AddUint64(&r.failures, 1)
+		r.breaker.record(trial, failed)
+	default:
+		r.breaker.record(trial, succeeded)
+	}
+}
+
+// do makes a call with the timeout, which is not retried.
+func (r *ResilientDatabase) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.call(ctx, true, fn)
+}
+
+// retry makes a call with the timeout, retrying it with
+// jittered exponential backoff while it fails in a way
+// which another attempt might not.
+func (r *ResilientDatabase) retry(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	err := r.call(ctx, true, fn)
+	for attempt := 0; attempt < r.opts.MaxRetries && isFailure(ctx, err); attempt++ {
+		if !r.pause(ctx, op, attempt, err) {
+			break
+		}
+		err = r.call(ctx, true, fn)
+	}
+	return err
+}
+
+// pause waits before the given retry, starting from 0, returning
+// false if ctx is done first.
+func (r *ResilientDatabase) pause(ctx context.Context, op string, attempt int, err error) bool {
+	delay := r.backoff(attempt)
+	log.Printf("%s: Retrying in %v after error: %v", op, delay, err)
+
+	select {
+	case <-time.After(delay):
+		atomic.AddUint64(&r.retries, 1)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backoff returns the delay before the given retry, starting from 0,
+// picked at random up to the exponentially growing limit.
+func (r *ResilientDatabase) backoff(attempt int) time.Duration {
+	limit := r.opts.Backoff << uint(attempt)
+	if limit <= 0 || (r.opts.MaxBackoff > 0 && limit > r.opts.MaxBackoff) {
+		limit = r.opts.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit))) + 1
+}
+
+// isFailure reports whether err suggests that the database is
+// unreachable or overloaded, rather than being an ordinary outcome
+// or caused by the caller going away.
+func isFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	return errors.Is(err, database.ErrUnavailable) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Connect connects to the database.
+func (r *ResilientDatabase) Connect(ctx context.Context) error {
+	return r.db.Connect(ctx)
+}
+
+// Disconnect disconnects from the database.
+func (r *ResilientDatabase) Disconnect(ctx context.Context) error {
+	return r.db.Disconnect(ctx)
+}
+
+// Endpoint returns the endpoint of the database.
+func (r *ResilientDatabase) Endpoint() string {
+	return r.db.Endpoint()
+}
+
+// Ping checks that the database can be reached, with the timeout.
+// It bypasses the circuit breaker, so that health checks report
+// on the database itself, and is not retried.
+func (r *ResilientDatabase) Ping(ctx context.Context) error {
+	if r.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.opts.Timeout)
+		defer cancel()
+	}
+	return r.db.Ping(ctx)
+}
+
+// CreateBlog creates a blog in the database.
+func (r *ResilientDatabase) CreateBlog(ctx context.Context, blog *blogpb.Blog) (res *blogpb.Blog, err error) {
+	err = r.do(ctx, func(ctx context.Context) error {
+		res, err = r.db.CreateBlog(ctx, blog)
+		return err
+	})
+	return res, err
+}
+
+// ReadBlog reads a blog from the database, retrying on failure.
+func (r *ResilientDatabase) ReadBlog(ctx context.Context, id string) (res *blogpb.Blog, err error) {
+	err = r.retry(ctx, "ReadBlog", func(ctx context.Context) error {
+		res, err = r.db.ReadBlog(ctx, id)
+		return err
+	})
+	return res, err
+}
+
+// UpdateBlog updates a blog in the database.
+func (r *ResilientDatabase) UpdateBlog(ctx context.Context, blog *blogpb.Blog, fields []database.Field, expectedVersion int64) (res *blogpb.Blog, err error) {
+	err = r.do(ctx, func(ctx context.Context) error {
+		res, err = r.db.UpdateBlog(ctx, blog, fields, expectedVersion)
+		return err
+	})
+	return res, err
+}
+
+// DeleteBlog moves a blog to the trash.
+func (r *ResilientDatabase) DeleteBlog(ctx context.Context, id string, expectedVersion int64) (res blogpb.DeleteBlogResponse_DeleteStatus, err error) {
+	err = r.do(ctx, func(ctx context.Context) error {
+		res, err = r.db.DeleteBlog(ctx, id, expectedVersion)
+		return err
+	})
+	return res, err
+}
+
+// PurgeBlog removes a blog from the database.
+func (r *ResilientDatabase) PurgeBlog(ctx context.Context, id string, expectedVersion int64) (res blogpb.DeleteBlogResponse_DeleteStatus, err error) {
+	err = r.do(ctx, func(ctx context.Context) error {
+		res, err = r.db.PurgeBlog(ctx, id, expectedVersion)
+		return err
+	})
+	return res, err
+}
+
+// UndeleteBlog restores a blog from the trash.
+func (r *ResilientDatabase) UndeleteBlog(ctx context.Context, id string) (res *blogpb.Blog, err error) {
+	err = r.do(ctx, func(ctx context.Context) error {
+		res, err = r.db.UndeleteBlog(ctx, id)
+		return err
+	})
+	return res, err
+}
+
+// PurgeDeletedBlogs removes the expired blogs from the trash.
+// It runs in the background in batches, so it has no timeout.
+func (r *ResilientDatabase) PurgeDeletedBlogs(ctx context.Context, before time.Time) (n int, err error) {
+	err = r.call(ctx, false, func(ctx context.Context) error {
+		n, err = r.db.PurgeDeletedBlogs(ctx, before)
+		return err
+	})
+	return n, err
+}
+
+// CreateBlogs creates several blogs.
+func (r *ResilientDatabase) CreateBlogs(ctx context.Context, blogs []*blogpb.Blog, allOrNothing bool) (res []*database.BatchResult, err error) {
+	err = r.do(ctx, func(ctx context.Context) error {
+		res, err = r.db.CreateBlogs(ctx, blogs, allOrNothing)
+		return err
+	})
+	return res, err
+}
+
+// ReadBlogs reads several blogs, retrying on failure.
+func (r *ResilientDatabase) ReadBlogs(ctx context.Context, ids []string) (res []*database.BatchResult, err error) {
+	err = r.retry(ctx, "ReadBlogs", func(ctx context.Context) error {
+		res, err = r.db.ReadBlogs(ctx, ids)
+		return err
+	})
+	return res, err
+}
+
+// DeleteBlogs deletes several blogs.
+func (r *ResilientDatabase) DeleteBlogs(ctx context.Context, ids []string, purge, allOrNothing bool) (res []*database.BatchResult, err error) {
+	err = r.do(ctx, func(ctx context.Context) error {
+		res, err = r.db.DeleteBlogs(ctx, ids, purge, allOrNothing)
+		return err
+	})
+	return res, err
+}
+
+// UpsertBlogs stores several blogs.
+func (r *ResilientDatabase) UpsertBlogs(ctx context.Context, blogs []*blogpb.Blog) (res []*database.UpsertResult, err error) {
+	err = r.do(ctx, func(ctx context.Context) error {
+		res, err = r.db.UpsertBlogs(ctx, blogs)
+		return err
+	})
+	return res, err
+}
+
+// ListBlogs lists the blogs in the database. A listing which fails
+// before any blog has been passed to fn is retried, but one which
+// fails part-way is not, since fn would see those blogs again. It
+// has no timeout, as a listing may stream every blog.
+//
+// The first blog shows that the database is answering, so it settles
+// the call with the circuit breaker. A listing which is the trial
+// call of the half-open breaker thus holds up other calls only until
+// its first blog arrives, not for as long as it streams.
+func (r *ResilientDatabase) ListBlogs(ctx context.Context, opts *database.ListOptions, fn func(*blogpb.Blog) error) error {
+	started := false
+	list := func() error {
+		trial, err := r.allow()
+		if err != nil {
+			return err
+		}
+
+		err = r.db.ListBlogs(ctx, opts, func(blog *blogpb.Blog) error {
+			if !started {
+				started = true
+				r.breaker.record(trial, succeeded)
+				// Later failures count as those of an ordinary call
+				trial = false
+			}
+			return fn(blog)
+		})
+
+		r.record(ctx, trial, err)
+		return err
+	}
+
+	err := list()
+	for attempt := 0; attempt < r.opts.MaxRetries && !started && isFailure(ctx, err); attempt++ {
+		if !r.pause(ctx, "ListBlogs", attempt, err) {
+			break
+		}
+		err = list()
+	}
+	return err
+}
+
+// SearchBlogs searches the blogs in the database, retrying on failure.
+func (r *ResilientDatabase) SearchBlogs(ctx context.Context, query string, limit int) (res []*database.SearchResult, err error) {
+	err = r.retry(ctx, "SearchBlogs", func(ctx context.Context) error {
+		res, err = r.db.SearchBlogs(ctx, query, limit)
+		return err
+	})
+	return res, err
+}
+
+// ListBlogRevisions lists the revisions of a blog, retrying on failure.
+func (r *ResilientDatabase) ListBlogRevisions(ctx context.Context, id string, before int64, limit int) (res []*blogpb.BlogRevision, err error) {
+	err = r.retry(ctx, "ListBlogRevisions", func(ctx context.Context) error {
+		res, err = r.db.ListBlogRevisions(ctx, id, before, limit)
+		return err
+	})
+	return res, err
+}
+
+// GetBlogRevision gets a revision of a blog, retrying on failure.
+func (r *ResilientDatabase) GetBlogRevision(ctx context.Context, id string, version int64) (res *blogpb.BlogRevision, err error) {
+	err = r.retry(ctx, "GetBlogRevision", func(ctx context.Context) error {
+		res, err = r.db.GetBlogRevision(ctx, id, version)
+		return err
+	})
+	return res, err
+}
+
+// WatchBlogs watches the changes to blogs. It passes straight through
+// on purpose: a watch runs for as long as its client likes, so it has
+// no timeout, and it is not recorded by the circuit breaker, where it
+// could hold up the trial call of the half-open state for that long.
+// Clients resume a failed watch with their last resume token.
+func (r *ResilientDatabase) WatchBlogs(ctx context.Context, opts *database.WatchOptions, fn func(*database.Change) error) error {
+	return r.db.WatchBlogs(ctx, opts, fn)
+}
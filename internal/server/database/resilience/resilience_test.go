@@ -0,0 +1,229 @@
+package resilience
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/database/memory"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDatabase is a Database whose ReadBlog, CreateBlog and
+// ListBlogs end with the error returned by fail, counting calls.
+type fakeDatabase struct {
+	database.Database
+	// Returns the error of a call, given its context
+	fail func(ctx context.Context) error
+	// The blogs ListBlogs passes to fn before failing
+	blogs []*blogpb.Blog
+	calls int
+}
+
+func newFakeDatabase(fail func(ctx context.Context) error) *fakeDatabase {
+	return &fakeDatabase{Database: memory.New(), fail: fail}
+}
+
+func (f *fakeDatabase) ReadBlog(ctx context.Context, id string) (*blogpb.Blog, error) {
+	f.calls++
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	return &blogpb.Blog{Id: id}, nil
+}
+
+func (f *fakeDatabase) CreateBlog(ctx context.Context, blog *blogpb.Blog) (*blogpb.Blog, error) {
+	f.calls++
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	return blog, nil
+}
+
+func (f *fakeDatabase) ListBlogs(ctx context.Context, opts *database.ListOptions, fn func(*blogpb.Blog) error) error {
+	f.calls++
+	for _, blog := range f.blogs {
+		if err := fn(blog); err != nil {
+			return err
+		}
+	}
+	return f.fail(ctx)
+}
+
+// The ways the calls of a fakeDatabase end
+var (
+	succeed     = func(ctx context.Context) error { return nil }
+	unavailable = func(ctx context.Context) error { return database.ErrUnavailable }
+	notFound    = func(ctx context.Context) error { return database.ErrNotFound }
+	hang        = func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() }
+)
+
+const testID = "5f00000000000000000000ab"
+
+func TestTimeout(t *testing.T) {
+	f := newFakeDatabase(hang)
+	r := New(f, Options{Timeout: 10 * time.Millisecond, FailureThreshold: 1, Cooldown: time.Minute})
+
+	_, err := r.CreateBlog(context.Background(), &blogpb.Blog{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CreateBlog returned %v, want a timeout", err)
+	}
+	if stats := r.Stats(); stats.Timeouts != 1 || stats.State != stateOpen {
+		t.Errorf("Got %d timeouts and state %s, want 1 and %s", stats.Timeouts, stats.State, stateOpen)
+	}
+}
+
+func TestCallerDeadlineIsNotATimeout(t *testing.T) {
+	f := newFakeDatabase(hang)
+	r := New(f, Options{Timeout: time.Minute, MaxRetries: 2, Backoff: time.Millisecond, FailureThreshold: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.ReadBlog(ctx, testID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReadBlog returned %v, want the caller's deadline", err)
+	}
+
+	stats := r.Stats()
+	if stats.Timeouts != 0 || stats.Failures != 0 || stats.State != stateClosed {
+		t.Errorf("Got %d timeouts, %d failures and state %s, want 0, 0 and %s",
+			stats.Timeouts, stats.Failures, stats.State, stateClosed)
+	}
+	if f.calls != 1 {
+		t.Errorf("Made %d calls, want 1", f.calls)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	const maxRetries = 2
+
+	tests := []struct {
+		name  string
+		fail  func(ctx context.Context) error
+		call  func(r *ResilientDatabase) error
+		calls int
+	}{
+		{
+			"failed read",
+			unavailable,
+			func(r *ResilientDatabase) error {
+				_, err := r.ReadBlog(context.Background(), testID)
+				return err
+			},
+			maxRetries + 1,
+		},
+		{
+			"missing blog",
+			notFound,
+			func(r *ResilientDatabase) error {
+				_, err := r.ReadBlog(context.Background(), testID)
+				return err
+			},
+			1,
+		},
+		{
+			"failed write",
+			unavailable,
+			func(r *ResilientDatabase) error {
+				_, err := r.CreateBlog(context.Background(), &blogpb.Blog{})
+				return err
+			},
+			1,
+		},
+	}
+
+	for _, tt := range tests {
+		f := newFakeDatabase(tt.fail)
+		r := New(f, Options{MaxRetries: maxRetries, Backoff: time.Millisecond})
+
+		if err := tt.call(r); err == nil {
+			t.Errorf("%s: Call succeeded, want an error", tt.name)
+		}
+		if f.calls != tt.calls {
+			t.Errorf("%s: Made %d calls, want %d", tt.name, f.calls, tt.calls)
+		}
+		if retries := r.Stats().Retries; retries != uint64(tt.calls-1) {
+			t.Errorf("%s: Got %d retries, want %d", tt.name, retries, tt.calls-1)
+		}
+	}
+}
+
+func TestListBlogsRetries(t *testing.T) {
+	const maxRetries = 2
+
+	tests := []struct {
+		name  string
+		blogs []*blogpb.Blog
+		calls int
+	}{
+		{"fails before the first blog", nil, maxRetries + 1},
+		{"fails after the first blog", []*blogpb.Blog{{Id: testID}}, 1},
+	}
+
+	for _, tt := range tests {
+		f := newFakeDatabase(unavailable)
+		f.blogs = tt.blogs
+		r := New(f, Options{MaxRetries: maxRetries, Backoff: time.Millisecond})
+
+		sent := 0
+		err := r.ListBlogs(context.Background(), nil, func(blog *blogpb.Blog) error {
+			sent++
+			return nil
+		})
+		if !errors.Is(err, database.ErrUnavailable) {
+			t.Errorf("%s: ListBlogs returned %v, want %v", tt.name, err, database.ErrUnavailable)
+		}
+		if f.calls != tt.calls || sent != len(tt.blogs) {
+			t.Errorf("%s: Made %d calls sending %d blogs, want %d calls sending %d",
+				tt.name, f.calls, sent, tt.calls, len(tt.blogs))
+		}
+	}
+}
+
+func TestListBlogsTrialSettlesOnFirstBlog(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeDatabase(unavailable)
+	r := New(f, Options{FailureThreshold: 1, Cooldown: 10 * time.Millisecond})
+
+	if _, err := r.ReadBlog(ctx, testID); !errors.Is(err, database.ErrUnavailable) {
+		t.Fatalf("ReadBlog returned %v, want %v", err, database.ErrUnavailable)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The listing is the trial call, and other calls go ahead
+	// while it is still streaming
+	f.fail = succeed
+	f.blogs = []*blogpb.Blog{{Id: testID}, {Id: testID}}
+	err := r.ListBlogs(ctx, nil, func(blog *blogpb.Blog) error {
+		_, err := r.ReadBlog(ctx, testID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ListBlogs returned %v", err)
+	}
+	if stats := r.Stats(); stats.State != stateClosed || stats.Rejected != 0 {
+		t.Errorf("Got state %s after %d rejected calls, want %s after 0", stats.State, stats.Rejected, stateClosed)
+	}
+}
+
+func TestCircuitOpenIsUnavailable(t *testing.T) {
+	ctx := context.Background()
+	r := New(newFakeDatabase(unavailable), Options{FailureThreshold: 1, Cooldown: time.Minute})
+	s := server.NewServer(r, nil)
+
+	req := &blogpb.ReadBlogRequest{Id: testID}
+	if _, err := s.ReadBlog(ctx, req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("ReadBlog returned %v, want %s", err, codes.Unavailable)
+	}
+	_, err := s.ReadBlog(ctx, req)
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("ReadBlog with the breaker open returned %s, want %s", code, codes.Unavailable)
+	}
+	if rejected := r.Stats().Rejected; rejected != 1 {
+		t.Errorf("Got %d rejected calls, want 1", rejected)
+	}
+}
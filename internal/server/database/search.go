@@ -0,0 +1,57 @@
+package database
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+)
+
+// SearchResult is a blog matching a search.
+type SearchResult struct {
+	Blog *blogpb.Blog
+	// The relevance of the blog to the search.
+	// Higher scores are more relevant.
+	Score float64
+}
+
+// The relative weights of matches in the title and content of a blog.
+const (
+	TitleWeight   = 10
+	ContentWeight = 1
+)
+
+// Tokenize splits text into lowercase search terms made of
+// letters and digits. It is used by backends without native
+// text search and for highlighting matches.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isTermRune(r)
+	})
+}
+
+// TermSpans returns the start and end byte offsets of the terms
+// in text, split as by Tokenize, so that they can be found in the
+// original text. The terms are not lowercased.
+func TermSpans(text string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range text {
+		switch {
+		case isTermRune(r) && start < 0:
+			start = i
+		case !isTermRune(r) && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// isTermRune reports whether r is part of a search term.
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
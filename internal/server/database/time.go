@@ -0,0 +1,59 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+)
+
+// The layout of times in Cursor.Value. It has a fixed width
+// in UTC, so that times sort in the same order as strings.
+const cursorTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// Now returns the current time at the millisecond
+// precision with which MongoDB stores times.
+func Now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// Timestamp converts a time to a protobuf timestamp,
+// returning nil for the zero time.
+func Timestamp(t time.Time) *timestamp.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	ts, err := ptypes.TimestampProto(t)
+	if err != nil {
+		return nil
+	}
+	return ts
+}
+
+// Time converts a protobuf timestamp to a time,
+// returning the zero time for a nil or invalid timestamp.
+func Time(ts *timestamp.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	t, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// FormatCursorTime formats a time in the form used by Cursor.Value.
+func FormatCursorTime(t time.Time) string {
+	return t.UTC().Format(cursorTimeLayout)
+}
+
+// ParseCursorTime parses a time in the form used by Cursor.Value.
+func ParseCursorTime(value string) (time.Time, error) {
+	t, err := time.Parse(cursorTimeLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid cursor time %q", value)
+	}
+	return t, nil
+}
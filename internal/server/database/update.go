@@ -0,0 +1,26 @@
+package database
+
+// Field names a field of a blog which may be updated.
+// The names match the fields of blogpb.Blog.
+type Field string
+
+// The fields which may be updated by UpdateBlog.
+const (
+	FieldAuthorID Field = "author_id"
+	FieldTitle    Field = "title"
+	FieldContent  Field = "content"
+)
+
+// UpdatableFields lists every field which may be updated,
+// which is the mask for replacing a whole blog.
+var UpdatableFields = []Field{FieldAuthorID, FieldTitle, FieldContent}
+
+// IsUpdatable reports whether a field may be updated.
+func IsUpdatable(field Field) bool {
+	for _, f := range UpdatableFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
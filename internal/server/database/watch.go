@@ -0,0 +1,49 @@
+package database
+
+import (
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+)
+
+// ChangeType is what happened to a blog in a Change.
+type ChangeType int
+
+// The kinds of change reported by WatchBlogs.
+const (
+	// A blog was created or restored from the trash
+	Created ChangeType = iota + 1
+	// A blog which is not in the trash was updated
+	Updated
+	// A blog was moved to the trash or purged
+	Deleted
+)
+
+// Change is a change to a blog reported by WatchBlogs.
+type Change struct {
+	Type ChangeType
+	// The blog after the change. Only the ID is
+	// set for a blog which was purged.
+	Blog *blogpb.Blog
+	// The token for resuming after this change
+	ResumeToken string
+}
+
+// WatchOptions specifies which changes are reported by WatchBlogs.
+type WatchOptions struct {
+	// Only report changes to blogs written by this author.
+	// Purges are reported whatever the author.
+	AuthorID string
+	// Only report changes after the one with this token. An
+	// empty token starts with the next change.
+	ResumeToken string
+}
+
+// Matches reports whether a change should be reported to a
+// watcher with the given options.
+func (opts *WatchOptions) Matches(change *Change) bool {
+	if opts == nil || opts.AuthorID == "" {
+		return true
+	}
+	// Purged blogs have no author
+	author := change.Blog.GetAuthorId()
+	return author == "" || author == opts.AuthorID
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/validation"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusError converts an error returned by the database into a
+// gRPC status error with the matching code, prefixing its message
+// with msg. Errors which already carry a status, such as those
+// returned by a failed stream.Send, are returned unchanged.
+func statusError(err error, msg string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return newStatus(err, msg).Err()
+}
+
+// responseError is like statusError, but also attaches res to the
+// status details when the error shows that the operation did not
+// take place, so that clients receive the failure status of the
+// response message along with the error code. Errors where the
+// outcome is unknown, such as an outage, carry no details, which
+// clients read as the UNKNOWN status.
+func responseError(err error, msg string, res proto.Message) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	st := newStatus(err, msg)
+	switch st.Code() {
+	case codes.NotFound, codes.InvalidArgument, codes.AlreadyExists, codes.Aborted:
+		st = withDetails(st, res)
+	}
+
+	return st.Err()
+}
+
+// newStatus creates the status for err, attaching the field
+// violations of invalid requests as BadRequest details.
+func newStatus(err error, msg string) *status.Status {
+	st := status.Newf(errorCode(err), "%s: %v", msg, err)
+
+	var verr *validation.Error
+	if errors.As(err, &verr) {
+		st = withDetails(st, verr.BadRequest())
+	}
+
+	return st
+}
+
+// withDetails attaches a detail to st, returning st
+// unchanged if the detail cannot be marshalled.
+func withDetails(st *status.Status, detail proto.Message) *status.Status {
+	if withDetails, err := st.WithDetails(detail); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// errorCode returns the gRPC code for an error returned by the database.
+func errorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, database.ErrInvalidID):
+		return codes.InvalidArgument
+	case errors.As(err, new(*validation.Error)):
+		return codes.InvalidArgument
+	case errors.Is(err, database.ErrConflict):
+		return codes.AlreadyExists
+	case errors.Is(err, database.ErrDeleted):
+		return codes.FailedPrecondition
+	case errors.Is(err, database.ErrVersionMismatch):
+		return codes.Aborted
+	case errors.Is(err, database.ErrUnavailable):
+		return codes.Unavailable
+	case errors.Is(err, database.ErrUnsupported):
+		return codes.Unimplemented
+	case errors.Is(err, database.ErrInvalidResumeToken):
+		return codes.InvalidArgument
+	case errors.Is(err, database.ErrResumeTokenExpired):
+		return codes.FailedPrecondition
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
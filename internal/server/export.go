@@ -0,0 +1,40 @@
+package server
+
+import (
+	"log"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExportBlogs streams every blog in the database which matches
+// the filter of the request.
+func (s *Server) ExportBlogs(req *blogpb.ExportBlogsRequest, stream blogpb.BlogService_ExportBlogsServer) error {
+	log.Printf("ExportBlogs: Invoked with filter %v and order %q", req.GetFilter(), req.GetOrderBy())
+
+	opts, err := listOptions(&blogpb.ListBlogsRequest{
+		Filter:  req.GetFilter(),
+		OrderBy: req.GetOrderBy(),
+	})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	exported := 0
+	send := func(blog *blogpb.Blog) error {
+		exported++
+		return stream.Send(blog)
+	}
+
+	ctx, cancel := s.streamContext(stream.Context())
+	defer cancel()
+
+	if err := s.db.ListBlogs(ctx, opts, send); err != nil {
+		return s.streamError(stream.Context(), err, "Error exporting documents")
+	}
+
+	log.Printf("ExportBlogs: Exported %d blogs", exported)
+
+	return nil
+}
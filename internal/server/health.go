@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/blogapi"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthServices are the services whose health is reported: the
+// server as a whole, named by the empty string, and BlogService.
+var healthServices = []string{"", blogapi.ServiceName}
+
+// NewHealthServer creates the standard gRPC health service, which
+// reports every service as NOT_SERVING until CheckHealth has
+// pinged the database.
+func NewHealthServer() *health.Server {
+	hs := health.NewServer()
+	for _, service := range healthServices {
+		hs.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	return hs
+}
+
+// CheckHealth pings the database at the given interval until ctx is
+// done, reporting every service as SERVING while the database can be
+// reached and NOT_SERVING while it cannot. Each ping may take up to
+// the interval. Once hs is shut down, it reports NOT_SERVING for good.
+func CheckHealth(ctx context.Context, db database.Database, hs *health.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	serving := false
+	for i := 0; ; i++ {
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := db.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err != nil && (serving || i == 0):
+			log.Printf("CheckHealth: Database is unreachable: %v", err)
+		case err == nil && !serving:
+			log.Println("CheckHealth: Database is reachable")
+		}
+		serving = err == nil
+
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if serving {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		for _, service := range healthServices {
+			hs.SetServingStatus(service, status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/dnys1/grpc-mongo/internal/blogapi"
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/validation"
+	"github.com/golang/protobuf/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultImportBatchSize is how many imported blogs are written
+// to the database at a time, unless configured otherwise.
+const DefaultImportBatchSize = 500
+
+// The most skipped or failed records listed in an ImportSummary
+const maxImportIssues = 100
+
+// ImportBlogs loads a stream of blogs into the database, writing
+// them in batches so that the stream is never held in memory.
+func (s *Server) ImportBlogs(stream blogpb.BlogService_ImportBlogsServer) error {
+	ctx := stream.Context()
+
+	upsert, err := importMode(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("ImportBlogs: Invoked (upsert %t, batch size %d)", upsert, s.importBatchSize)
+
+	imp := &importer{
+		db:      s.db,
+		upsert:  upsert,
+		size:    s.importBatchSize,
+		summary: &blogpb.ImportSummary{},
+		ids:     make(map[primitive.ObjectID]bool),
+	}
+
+	for index := int64(0); ; index++ {
+		blog, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := imp.add(ctx, index, blog); err != nil {
+			return imp.abort(err)
+		}
+	}
+	if err := imp.flush(ctx); err != nil {
+		return imp.abort(err)
+	}
+
+	sum := imp.summary
+	imp.trimIssues()
+
+	log.Printf("ImportBlogs: %d inserted, %d updated, %d skipped, %d failed", sum.Inserted, sum.Updated, sum.Skipped, sum.Failed)
+
+	return stream.SendAndClose(sum)
+}
+
+// importMode returns whether the import in ctx is in upsert mode.
+func importMode(ctx context.Context) (bool, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(blogapi.ImportModeHeader)
+	if len(values) == 0 {
+		return false, nil
+	}
+
+	switch mode := strings.ToLower(values[0]); mode {
+	case "", "create":
+		return false, nil
+	case "upsert":
+		return true, nil
+	default:
+		return false, status.Errorf(codes.InvalidArgument, "Unknown import mode %q", mode)
+	}
+}
+
+// importer buffers the blogs of an import and writes them to the
+// database in batches, keeping count of what happened to each.
+type importer struct {
+	db      database.Database
+	upsert  bool
+	size    int
+	summary *blogpb.ImportSummary
+
+	// The buffered blogs, along with their index in the stream
+	blogs   []*blogpb.Blog
+	indexes []int64
+	// The IDs of the buffered blogs, in upsert mode
+	ids map[primitive.ObjectID]bool
+}
+
+// add buffers a blog, writing the buffer if it is full.
+func (imp *importer) add(ctx context.Context, index int64, blog *blogpb.Blog) error {
+	if proto.Equal(blog, &blogpb.Blog{}) {
+		imp.skip(index, blog, "Record is empty")
+		return nil
+	}
+	if err := validation.ImportBlog(blog, imp.upsert); err != nil {
+		imp.fail(index, blog, newStatus(err, "Error validating blog").Proto())
+		return nil
+	}
+
+	if !imp.upsert {
+		// Blogs are created afresh, as with CreateBlog
+		blog = &blogpb.Blog{
+			AuthorId: blog.GetAuthorId(),
+			Title:    blog.GetTitle(),
+			Content:  blog.GetContent(),
+		}
+	} else if id := blog.GetId(); id != "" {
+		// Write any earlier blog with the same ID first, so that the
+		// later one is applied as an update of it. IDs are compared
+		// parsed, as hex IDs which differ in case name the same blog.
+		oid, err := database.ParseID(id)
+		if err != nil {
+			return err
+		}
+		if imp.ids[oid] {
+			if err := imp.flush(ctx); err != nil {
+				return err
+			}
+		}
+		imp.ids[oid] = true
+	}
+
+	imp.blogs = append(imp.blogs, blog)
+	imp.indexes = append(imp.indexes, index)
+	if len(imp.blogs) >= imp.size {
+		return imp.flush(ctx)
+	}
+	return nil
+}
+
+// flush writes the buffered blogs to the database.
+func (imp *importer) flush(ctx context.Context) error {
+	if len(imp.blogs) == 0 {
+		return nil
+	}
+
+	if imp.upsert {
+		res, err := imp.db.UpsertBlogs(ctx, imp.blogs)
+		if err != nil {
+			return err
+		}
+		for i, r := range res {
+			switch {
+			case r.Err != nil:
+				imp.fail(imp.indexes[i], imp.blogs[i], itemStatus(r.Err, "Error storing document"))
+			case r.Unchanged:
+				imp.skip(imp.indexes[i], imp.blogs[i], "Blog is unchanged")
+			case r.Created:
+				imp.summary.Inserted++
+			default:
+				imp.summary.Updated++
+			}
+		}
+	} else {
+		res, err := imp.db.CreateBlogs(ctx, imp.blogs, false)
+		if err != nil {
+			return err
+		}
+		for i, r := range res {
+			if r.Err != nil {
+				imp.fail(imp.indexes[i], imp.blogs[i], itemStatus(r.Err, "Error inserting document"))
+			} else {
+				imp.summary.Inserted++
+			}
+		}
+	}
+
+	imp.blogs = imp.blogs[:0]
+	imp.indexes = imp.indexes[:0]
+	imp.ids = make(map[primitive.ObjectID]bool)
+	return nil
+}
+
+// skip records a record which was left alone.
+func (imp *importer) skip(index int64, blog *blogpb.Blog, reason string) {
+	imp.summary.Skipped++
+	imp.issue(&blogpb.ImportIssue{
+		Index:   index,
+		Id:      blog.GetId(),
+		Outcome: blogpb.ImportIssue_SKIPPED,
+		Reason:  reason,
+	})
+}
+
+// fail records a record which could not be imported.
+func (imp *importer) fail(index int64, blog *blogpb.Blog, st *spb.Status) {
+	imp.summary.Failed++
+	imp.issue(&blogpb.ImportIssue{
+		Index:   index,
+		Id:      blog.GetId(),
+		Outcome: blogpb.ImportIssue_FAILED,
+		Reason:  st.GetMessage(),
+		Error:   st,
+	})
+}
+
+// issue records an issue for the summary. Issues are not recorded
+// in the order of their records, as those which fail validation are
+// found before earlier records in the same batch fail to be written,
+// so the issues with the lowest indexes are only known at the end.
+// Meanwhile, at most twice the number which are reported are kept.
+func (imp *importer) issue(issue *blogpb.ImportIssue) {
+	imp.summary.Issues = append(imp.summary.Issues, issue)
+	if len(imp.summary.Issues) > 2*maxImportIssues {
+		imp.trimIssues()
+	}
+}
+
+// trimIssues sorts the issues of the summary by index,
+// keeping only the first maxImportIssues.
+func (imp *importer) trimIssues() {
+	issues := imp.summary.Issues
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].Index < issues[j].Index
+	})
+	if len(issues) > maxImportIssues {
+		issues = issues[:maxImportIssues]
+	}
+	imp.summary.Issues = issues
+}
+
+// abort returns the error for an import which could not write to
+// the database, saying how far it got, since earlier batches have
+// already been written.
+func (imp *importer) abort(err error) error {
+	sum := imp.summary
+	log.Printf("ImportBlogs: Aborted after %d inserted and %d updated: %v", sum.Inserted, sum.Updated, err)
+	msg := fmt.Sprintf("Error importing documents (%d inserted, %d updated)", sum.Inserted, sum.Updated)
+	return newStatus(err, msg).Err()
+}
@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/blogapi"
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database/memory"
+	"google.golang.org/grpc/metadata"
+)
+
+// importBlogs imports blogs in upsert mode, returning the summary.
+func importBlogs(t *testing.T, c blogpb.BlogServiceClient, blogs []*blogpb.Blog) *blogpb.ImportSummary {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, blogapi.ImportModeHeader, "upsert")
+
+	stream, err := c.ImportBlogs(ctx)
+	if err != nil {
+		t.Fatalf("Error starting import: %v", err)
+	}
+	for _, blog := range blogs {
+		if err := stream.Send(blog); err != nil {
+			t.Fatalf("Error sending blog: %v", err)
+		}
+	}
+	sum, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("Error importing blogs: %v", err)
+	}
+	return sum
+}
+
+func TestImportReportsFirstIssues(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	c := dial(t, db)
+
+	// Blogs in the trash fail when they are written, after the
+	// whole batch has been validated
+	var blogs []*blogpb.Blog
+	for i := 0; i < 120; i++ {
+		blog, err := db.CreateBlog(ctx, &blogpb.Blog{AuthorId: "author", Title: "Title"})
+		if err != nil {
+			t.Fatalf("Error creating blog: %v", err)
+		}
+		if _, err := db.DeleteBlog(ctx, blog.GetId(), 0); err != nil {
+			t.Fatalf("Error deleting blog: %v", err)
+		}
+		blogs = append(blogs, &blogpb.Blog{Id: blog.GetId(), AuthorId: "author", Title: "Edited"})
+	}
+	// Blogs without a title fail as soon as they are received
+	for i := 0; i < 120; i++ {
+		blogs = append(blogs, &blogpb.Blog{AuthorId: "author"})
+	}
+
+	sum := importBlogs(t, c, blogs)
+	if sum.GetFailed() != 240 {
+		t.Errorf("Failed %d records, want 240", sum.GetFailed())
+	}
+	if len(sum.GetIssues()) != 100 {
+		t.Fatalf("Got %d issues, want 100", len(sum.GetIssues()))
+	}
+	for i, issue := range sum.GetIssues() {
+		if issue.GetIndex() != int64(i) {
+			t.Fatalf("Issue %d is for record %d, want %d", i, issue.GetIndex(), i)
+		}
+	}
+}
+
+func TestImportMatchesIDsRegardlessOfCase(t *testing.T) {
+	c := dial(t, memory.New())
+
+	id := "5f00000000000000000000ab"
+	sum := importBlogs(t, c, []*blogpb.Blog{
+		{Id: id, AuthorId: "author", Title: "First"},
+		{Id: strings.ToUpper(id), AuthorId: "author", Title: "Second"},
+	})
+	if sum.GetInserted() != 1 || sum.GetUpdated() != 1 || sum.GetFailed() != 0 {
+		t.Errorf("Got %d inserted, %d updated and %d failed, want 1, 1 and 0 (%v)",
+			sum.GetInserted(), sum.GetUpdated(), sum.GetFailed(), sum.GetIssues())
+	}
+}
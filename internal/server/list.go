@@ -0,0 +1,175 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+)
+
+// listOptions validates a ListBlogsRequest and converts
+// it to the options for database.ListBlogs, without a limit.
+func listOptions(req *blogpb.ListBlogsRequest) (*database.ListOptions, error) {
+	if req.GetPageSize() < 0 {
+		return nil, errors.New("Page size must not be negative")
+	}
+
+	filter, err := listFilter(req.GetFilter())
+	if err != nil {
+		return nil, err
+	}
+
+	sort, err := listSort(req.GetOrderBy())
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := decodePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &database.ListOptions{
+		Filter: filter,
+		Sort:   sort,
+	}
+	if tok != nil {
+		if tok.OrderBy != sortString(sort) {
+			return nil, errors.New("Page token does not match order_by")
+		}
+		if database.IsTimeField(sort.Field) {
+			if _, err := database.ParseCursorTime(tok.LastValue); err != nil {
+				return nil, errInvalidPageToken
+			}
+		}
+		opts.After = &database.Cursor{
+			ID:    tok.LastID,
+			Value: tok.LastValue,
+		}
+	}
+
+	return opts, nil
+}
+
+// listFilter validates the filter of a ListBlogsRequest and
+// converts it to a database.Filter.
+func listFilter(f *blogpb.BlogFilter) (database.Filter, error) {
+	filter := database.Filter{
+		AuthorID:      f.GetAuthorId(),
+		TitlePrefix:   f.GetTitlePrefix(),
+		TitleContains: f.GetTitleContains(),
+		MinID:         f.GetMinId(),
+		MaxID:         f.GetMaxId(),
+	}
+
+	minCreate, maxCreate, err := timeRange("create_time", f.GetMinCreateTime(), f.GetMaxCreateTime())
+	if err != nil {
+		return filter, err
+	}
+	filter.MinCreateTime, filter.MaxCreateTime = minCreate, maxCreate
+
+	minUpdate, maxUpdate, err := timeRange("update_time", f.GetMinUpdateTime(), f.GetMaxUpdateTime())
+	if err != nil {
+		return filter, err
+	}
+	filter.MinUpdateTime, filter.MaxUpdateTime = minUpdate, maxUpdate
+
+	if filter.MinID != "" {
+		oid, err := database.ParseID(filter.MinID)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid filter.min_id %q", filter.MinID)
+		}
+		filter.MinID = oid.Hex()
+	}
+	if filter.MaxID != "" {
+		oid, err := database.ParseID(filter.MaxID)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid filter.max_id %q", filter.MaxID)
+		}
+		filter.MaxID = oid.Hex()
+	}
+	if filter.MinID != "" && filter.MaxID != "" && filter.MinID >= filter.MaxID {
+		return filter, fmt.Errorf("filter.min_id must be less than filter.max_id")
+	}
+
+	return filter, nil
+}
+
+// timeRange validates the bounds of a time filter on the given
+// field, where a nil bound is unbounded.
+func timeRange(field string, min, max *timestamp.Timestamp) (time.Time, time.Time, error) {
+	var minTime, maxTime time.Time
+	if min != nil {
+		t, err := ptypes.Timestamp(min)
+		if err != nil {
+			return minTime, maxTime, fmt.Errorf("Invalid filter.min_%s: %v", field, err)
+		}
+		minTime = t
+	}
+	if max != nil {
+		t, err := ptypes.Timestamp(max)
+		if err != nil {
+			return minTime, maxTime, fmt.Errorf("Invalid filter.max_%s: %v", field, err)
+		}
+		maxTime = t
+	}
+	if min != nil && max != nil && !minTime.Before(maxTime) {
+		return minTime, maxTime, fmt.Errorf("filter.min_%s must be before filter.max_%s", field, field)
+	}
+	return minTime, maxTime, nil
+}
+
+// sortableFields is the allow-list of fields which
+// may be used in the order_by of a ListBlogsRequest.
+var sortableFields = map[string]database.SortField{
+	"id":          database.SortByID,
+	"author_id":   database.SortByAuthorID,
+	"title":       database.SortByTitle,
+	"create_time": database.SortByCreateTime,
+	"update_time": database.SortByUpdateTime,
+}
+
+// listSort parses the order_by of a ListBlogsRequest.
+func listSort(orderBy string) (database.Sort, error) {
+	sort := database.Sort{Field: database.SortByID}
+
+	parts := strings.Fields(strings.ToLower(orderBy))
+	if len(parts) == 0 {
+		return sort, nil
+	}
+	if len(parts) > 2 {
+		return sort, fmt.Errorf("Invalid order_by %q", orderBy)
+	}
+
+	field, ok := sortableFields[parts[0]]
+	if !ok {
+		return sort, fmt.Errorf("Cannot order by %q", parts[0])
+	}
+	sort.Field = field
+
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "asc":
+		case "desc":
+			sort.Descending = true
+		default:
+			return sort, fmt.Errorf("Invalid sort direction %q", parts[1])
+		}
+	}
+
+	return sort, nil
+}
+
+// sortString returns the canonical order_by for a sort
+// order, which is used to match page tokens to requests.
+func sortString(sort database.Sort) string {
+	if sort.Descending {
+		return string(sort.Field) + " desc"
+	}
+	return string(sort.Field) + " asc"
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/pkg/errors"
+)
+
+const (
+	// The page size used when a request does not specify one
+	defaultPageSize = 50
+	// The largest page size a request may ask for
+	maxPageSize = 1000
+)
+
+var (
+	errInvalidPageToken = errors.New("Invalid page token")
+)
+
+// pageToken is the decoded form of the opaque page tokens
+// handed out to clients.
+type pageToken struct {
+	// The ID of the last blog on the previous page
+	LastID string `json:"id"`
+	// The sort value of the last blog on the previous page
+	LastValue string `json:"v,omitempty"`
+	// The canonical order_by of the request
+	OrderBy string `json:"o,omitempty"`
+}
+
+// newPageToken creates the token for the page following
+// the given blog, in the order of the request.
+func newPageToken(last *blogpb.Blog, sort database.Sort) *pageToken {
+	return &pageToken{
+		LastID:    last.GetId(),
+		LastValue: database.SortValue(last, sort.Field),
+		OrderBy:   sortString(sort),
+	}
+}
+
+// encodePageToken encodes a page token as a URL-safe string.
+func encodePageToken(tok *pageToken) string {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		// Marshalling a struct of strings cannot fail
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodePageToken decodes a page token received from a client.
+// An empty string decodes to a nil token.
+func decodePageToken(s string) (*pageToken, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+
+	tok := &pageToken{}
+	if err := json.Unmarshal(b, tok); err != nil {
+		return nil, errInvalidPageToken
+	}
+
+	if _, err := database.ParseID(tok.LastID); err != nil {
+		return nil, errInvalidPageToken
+	}
+
+	return tok, nil
+}
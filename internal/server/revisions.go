@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/validation"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The canonical order of revision page tokens
+const revisionOrder = "version desc"
+
+// ListBlogRevisions lists a single page of the revisions of a blog, newest first.
+func (s *Server) ListBlogRevisions(ctx context.Context, req *blogpb.ListBlogRevisionsRequest) (*blogpb.ListBlogRevisionsResponse, error) {
+	id := req.GetId()
+	log.Printf("ListBlogRevisions: Invoked with id %s and page size %d", id, req.GetPageSize())
+
+	if err := validation.ListBlogRevisions(req); err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	before, err := revisionCursor(id, req.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	pageSize := int(req.GetPageSize())
+	switch {
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	// Fetch one extra revision to find out whether there is another page
+	revisions, err := s.db.ListBlogRevisions(ctx, id, before, pageSize+1)
+	if err != nil {
+		return nil, statusError(err, "Error listing revisions")
+	}
+
+	res := &blogpb.ListBlogRevisionsResponse{}
+	if len(revisions) > pageSize {
+		revisions = revisions[:pageSize]
+		res.NextPageToken = encodePageToken(&pageToken{
+			LastID:    id,
+			LastValue: strconv.FormatInt(revisions[pageSize-1].GetBlog().GetVersion(), 10),
+			OrderBy:   revisionOrder,
+		})
+	}
+	res.Revisions = revisions
+
+	log.Printf("ListBlogRevisions: Returning %d revisions", len(revisions))
+
+	return res, nil
+}
+
+// revisionCursor decodes the page token of a ListBlogRevisionsRequest
+// for the given blog, returning the version which the next page comes
+// before, or zero for the first page.
+func revisionCursor(id, token string) (int64, error) {
+	tok, err := decodePageToken(token)
+	if err != nil || tok == nil {
+		return 0, err
+	}
+	if tok.LastID != id || tok.OrderBy != revisionOrder {
+		return 0, errInvalidPageToken
+	}
+
+	before, err := strconv.ParseInt(tok.LastValue, 10, 64)
+	if err != nil || before <= 0 {
+		return 0, errInvalidPageToken
+	}
+	return before, nil
+}
+
+// GetBlogRevision gets a single revision of a blog.
+func (s *Server) GetBlogRevision(ctx context.Context, req *blogpb.GetBlogRevisionRequest) (*blogpb.BlogRevision, error) {
+	log.Printf("GetBlogRevision: Invoked with id %s and version %d", req.GetId(), req.GetVersion())
+
+	if err := validation.GetBlogRevision(req); err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	res, err := s.db.GetBlogRevision(ctx, req.GetId(), req.GetVersion())
+	if err != nil {
+		return nil, statusError(err, "Error retrieving revision")
+	}
+
+	log.Println("GetBlogRevision: Revision successfully found")
+
+	return res, nil
+}
+
+// RestoreBlogRevision restores the author, title and content of a
+// blog to those of a revision. This is an update, so the replaced
+// version is recorded as a revision in turn.
+func (s *Server) RestoreBlogRevision(ctx context.Context, req *blogpb.RestoreBlogRevisionRequest) (*blogpb.RestoreBlogRevisionResponse, error) {
+	id := req.GetId()
+	log.Printf("RestoreBlogRevision: Invoked with id %s and version %d", id, req.GetVersion())
+
+	if err := validation.RestoreBlogRevision(req); err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	version, err := expectedVersion(ctx, req.GetExpectedVersion())
+	if err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	rev, err := s.db.GetBlogRevision(ctx, id, req.GetVersion())
+	if err != nil {
+		return nil, statusError(err, "Error retrieving revision")
+	}
+
+	blog := rev.GetBlog()
+	res, err := s.db.UpdateBlog(ctx, blog, database.UpdatableFields, version)
+	if err != nil {
+		return nil, statusError(err, "Error updating document")
+	}
+
+	log.Printf("RestoreBlogRevision: Blog successfully restored (version %d)", res.GetVersion())
+
+	return &blogpb.RestoreBlogRevisionResponse{
+		Blog: res,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/database/memory"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRevisionsOfDeletedBlogs(t *testing.T) {
+	ctx := context.Background()
+	db := memory.New()
+	c := dial(t, db)
+
+	blog, err := db.CreateBlog(ctx, &blogpb.Blog{AuthorId: "author", Title: "Title"})
+	if err != nil {
+		t.Fatalf("Error creating blog: %v", err)
+	}
+	id := blog.GetId()
+	blog.Title = "Edited"
+	if _, err := db.UpdateBlog(ctx, blog, database.UpdatableFields, 0); err != nil {
+		t.Fatalf("Error updating blog: %v", err)
+	}
+
+	check := func(state string, want codes.Code) {
+		rev, err := c.GetBlogRevision(ctx, &blogpb.GetBlogRevisionRequest{Id: id, Version: 1})
+		if code := status.Code(err); code != want {
+			t.Errorf("GetBlogRevision of %s blog returned %s, want %s (%v)", state, code, want, err)
+		}
+		if err == nil && rev.GetBlog().GetTitle() != "Title" {
+			t.Errorf("Revision 1 of %s blog has title %q, want %q", state, rev.GetBlog().GetTitle(), "Title")
+		}
+		_, err = c.ListBlogRevisions(ctx, &blogpb.ListBlogRevisionsRequest{Id: id})
+		if code := status.Code(err); code != want {
+			t.Errorf("ListBlogRevisions of %s blog returned %s, want %s (%v)", state, code, want, err)
+		}
+		_, err = c.RestoreBlogRevision(ctx, &blogpb.RestoreBlogRevisionRequest{Id: id, Version: 1})
+		if code := status.Code(err); code != want {
+			t.Errorf("RestoreBlogRevision of %s blog returned %s, want %s (%v)", state, code, want, err)
+		}
+	}
+
+	check("live", codes.OK)
+
+	if _, err := db.DeleteBlog(ctx, id, 0); err != nil {
+		t.Fatalf("Error deleting blog: %v", err)
+	}
+	check("trashed", codes.FailedPrecondition)
+
+	if _, err := db.PurgeBlog(ctx, id, 0); err != nil {
+		t.Fatalf("Error purging blog: %v", err)
+	}
+	check("purged", codes.NotFound)
+}
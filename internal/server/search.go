@@ -0,0 +1,111 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+)
+
+const (
+	// The number of results returned when a search does not specify one
+	defaultSearchSize = 20
+	// The largest number of results a search may ask for
+	maxSearchSize = 100
+	// The number of characters of context kept on each side of a match
+	snippetContext = 40
+)
+
+// highlighter finds the search terms in text and
+// builds snippets around them. Terms are matched exactly,
+// without the stemming of MongoDB's text search.
+type highlighter struct {
+	terms map[string]bool
+}
+
+// newHighlighter creates a highlighter for the terms of query.
+// It returns nil if the query has no terms.
+func newHighlighter(query string) *highlighter {
+	terms := database.Tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	h := &highlighter{terms: make(map[string]bool)}
+	for _, term := range terms {
+		h.terms[term] = true
+	}
+	return h
+}
+
+// matches returns the byte offsets of the search terms in text. The
+// text is split into terms as the database does, rather than with a
+// regexp, whose word boundaries only know about ASCII letters.
+func (h *highlighter) matches(text string) [][2]int {
+	var matches [][2]int
+	for _, span := range database.TermSpans(text) {
+		if h.terms[strings.ToLower(text[span[0]:span[1]])] {
+			matches = append(matches, span)
+		}
+	}
+	return matches
+}
+
+// snippets returns the highlighted snippets for a blog: the
+// title if it matches, and the first match in the content.
+func (h *highlighter) snippets(blog *blogpb.Blog) []string {
+	var snippets []string
+	for _, text := range []string{blog.GetTitle(), blog.GetContent()} {
+		if snippet, ok := h.snippet(text); ok {
+			snippets = append(snippets, snippet)
+		}
+	}
+	return snippets
+}
+
+// snippet returns the text surrounding the first match in
+// text, with every match in that window wrapped in <em> tags.
+func (h *highlighter) snippet(text string) (string, bool) {
+	matches := h.matches(text)
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	start, end := matches[0][0]-snippetContext, matches[0][1]+snippetContext
+	prefix, suffix := "…", "…"
+	if start <= 0 {
+		start, prefix = 0, ""
+	}
+	if end >= len(text) {
+		end, suffix = len(text), ""
+	}
+
+	// Avoid cutting multi-byte characters in half
+	for start > 0 && !isRuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !isRuneStart(text[end]) {
+		end++
+	}
+
+	// Highlight the matches which lie wholly within the window
+	var b strings.Builder
+	b.WriteString(prefix)
+	last := start
+	for _, m := range matches {
+		if m[0] < start || m[1] > end {
+			continue
+		}
+		b.WriteString(text[last:m[0]])
+		b.WriteString("<em>" + text[m[0]:m[1]] + "</em>")
+		last = m[1]
+	}
+	b.WriteString(text[last:end])
+	b.WriteString(suffix)
+	return b.String(), true
+}
+
+// isRuneStart reports whether b is the first byte of a UTF-8 encoded rune.
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,22 @@
+package server
+
+import "testing"
+
+func TestHighlighterSnippet(t *testing.T) {
+	tests := []struct {
+		query, text, want string
+	}{
+		{"go", "Go is fun, go!", "<em>Go</em> is fun, <em>go</em>!"},
+		{"go", "Going, gone", ""},
+		{"café", "Un café, s'il vous plaît", "Un <em>café</em>, s'il vous plaît"},
+		{"über", "Alles über Über-Blogs", "Alles <em>über</em> <em>Über</em>-Blogs"},
+		{"caf", "Un café", ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := newHighlighter(tt.query).snippet(tt.text)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("snippet(%q) for %q = %q, %t, want %q", tt.text, tt.query, got, ok, tt.want)
+		}
+	}
+}
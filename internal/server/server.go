@@ -3,9 +3,11 @@ package server
 import (
 	"context"
 	"log"
+	"sync"
 
 	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
 	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/validation"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -23,14 +25,36 @@ type blogItem struct {
 type Server struct {
 	// The database for the server
 	db database.Database
+	// How many imported blogs are written to the database at a time
+	importBatchSize int
+	// Closed by Shutdown to end the streaming reads in progress
+	stopping chan struct{}
+	stopOnce sync.Once
 	blogpb.UnimplementedBlogServiceServer
 }
 
+// Options configures a Server. A nil *Options uses the defaults.
+type Options struct {
+	// How many blogs ImportBlogs writes to the database at a time,
+	// or zero for DefaultImportBatchSize.
+	ImportBatchSize int
+}
+
 // NewServer creates a new Server object.
-func NewServer(db database.Database) *Server {
-	return &Server{
-		db: db,
+func NewServer(db database.Database, opts *Options) *Server {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	s := &Server{
+		db:              db,
+		importBatchSize: opts.ImportBatchSize,
+		stopping:        make(chan struct{}),
+	}
+	if s.importBatchSize <= 0 {
+		s.importBatchSize = DefaultImportBatchSize
 	}
+	return s
 }
 
 // CreateBlog creates a blog in the database.
@@ -38,9 +62,13 @@ func (s *Server) CreateBlog(ctx context.Context, req *blogpb.CreateBlogRequest)
 	blog := req.GetBlog()
 	log.Printf("CreateBlog: Invoked with blog item %v", blog)
 
+	if err := validation.CreateBlog(req); err != nil {
+		return nil, statusError(err, "Error validating blog")
+	}
+
 	res, err := s.db.CreateBlog(ctx, blog)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Error inserting document: %v", err)
+		return nil, statusError(err, "Error inserting document")
 	}
 
 	log.Printf("CreateBlog: Blog item successfully created (id %s)", res.GetId())
@@ -55,55 +83,214 @@ func (s *Server) ReadBlog(ctx context.Context, req *blogpb.ReadBlogRequest) (*bl
 	id := req.GetId()
 	log.Printf("ReadBlog: Invoked with id %s", id)
 
+	if err := validation.ReadBlog(req); err != nil {
+		return nil, responseError(err, "Error validating request", &blogpb.ReadBlogResponse{
+			Status: blogpb.ReadBlogResponse_NOT_FOUND,
+		})
+	}
+
 	res, err := s.db.ReadBlog(ctx, id)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Error retrieving document: %v", err)
+		return nil, responseError(err, "Error retrieving document", &blogpb.ReadBlogResponse{
+			Status: blogpb.ReadBlogResponse_NOT_FOUND,
+		})
 	}
 
 	log.Println("ReadBlog: Blog successfully found")
 
 	return &blogpb.ReadBlogResponse{
-		Blog: res,
+		Blog:   res,
+		Status: blogpb.ReadBlogResponse_FOUND,
 	}, nil
 }
 
 // UpdateBlog updates a blog in the database.
 func (s *Server) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogRequest) (*blogpb.UpdateBlogResponse, error) {
 	blog := req.GetBlog()
-	log.Printf("UpdateBlog: Invoked with blog %v", blog)
+	log.Printf("UpdateBlog: Invoked with blog %v and mask %v", blog, req.GetUpdateMask().GetPaths())
+
+	if err := validation.UpdateBlog(req); err != nil {
+		return nil, responseError(err, "Error validating blog", &blogpb.UpdateBlogResponse{
+			Status: blogpb.UpdateBlogResponse_NOT_UPDATED,
+		})
+	}
+
+	version, err := expectedVersion(ctx, req.GetExpectedVersion())
+	if err != nil {
+		return nil, responseError(err, "Error validating blog", &blogpb.UpdateBlogResponse{
+			Status: blogpb.UpdateBlogResponse_NOT_UPDATED,
+		})
+	}
 
-	res, err := s.db.UpdateBlog(ctx, blog)
+	res, err := s.db.UpdateBlog(ctx, blog, validation.UpdateFields(req), version)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Error updating document: %v", err)
+		return nil, responseError(err, "Error updating document", &blogpb.UpdateBlogResponse{
+			Status: blogpb.UpdateBlogResponse_NOT_UPDATED,
+		})
 	}
 
+	log.Printf("UpdateBlog: Blog successfully updated (version %d)", res.GetVersion())
+
 	return &blogpb.UpdateBlogResponse{
-		Status: res,
+		Status: blogpb.UpdateBlogResponse_UPDATED,
+		Blog:   res,
 	}, nil
 }
 
-// DeleteBlog deletes a blog from the database.
+// DeleteBlog moves a blog to the trash, or removes it
+// from the database if the request asks to purge it.
 func (s *Server) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogRequest) (*blogpb.DeleteBlogResponse, error) {
 	id := req.GetId()
-	log.Printf("DeleteBlog: Invoked with id %v", id)
+	log.Printf("DeleteBlog: Invoked with id %v (purge %t)", id, req.GetPurge())
 
-	res, err := s.db.DeleteBlog(ctx, id)
+	if err := validation.DeleteBlog(req); err != nil {
+		return nil, responseError(err, "Error validating request", &blogpb.DeleteBlogResponse{
+			Status: blogpb.DeleteBlogResponse_NOT_DELETED,
+		})
+	}
+
+	version, err := expectedVersion(ctx, req.GetExpectedVersion())
+	if err != nil {
+		return nil, responseError(err, "Error validating request", &blogpb.DeleteBlogResponse{
+			Status: blogpb.DeleteBlogResponse_NOT_DELETED,
+		})
+	}
+
+	deleteBlog := s.db.DeleteBlog
+	if req.GetPurge() {
+		deleteBlog = s.db.PurgeBlog
+	}
+
+	res, err := deleteBlog(ctx, id, version)
+	if err == nil && res != blogpb.DeleteBlogResponse_DELETED {
+		err = database.ErrNotFound
+	}
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Error deleting document: %v", err)
+		return nil, responseError(err, "Error deleting document", &blogpb.DeleteBlogResponse{
+			Status: blogpb.DeleteBlogResponse_NOT_DELETED,
+		})
 	}
 
+	log.Println("DeleteBlog: Blog successfully deleted")
+
 	return &blogpb.DeleteBlogResponse{
-		Status: res,
+		Status: blogpb.DeleteBlogResponse_DELETED,
 	}, nil
 }
 
 // ListBlogs lists all the blogs in the database.
 func (s *Server) ListBlogs(req *blogpb.ListBlogsRequest, stream blogpb.BlogService_ListBlogsServer) error {
-	log.Println("ListBlog: Invoked with no parameters")
+	log.Printf("ListBlogs: Invoked with page size %d", req.GetPageSize())
 
-	if err := s.db.ListBlogs(stream); err != nil {
-		return status.Errorf(codes.Internal, "Error listing documents: %v", err)
+	opts, err := listOptions(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts.Limit = int(req.GetPageSize())
+
+	send := func(blog *blogpb.Blog) error {
+		return stream.Send(&blogpb.ListBlogsResponse{Blog: blog})
+	}
+
+	ctx, cancel := s.streamContext(stream.Context())
+	defer cancel()
+
+	if err := s.db.ListBlogs(ctx, opts, send); err != nil {
+		return s.streamError(stream.Context(), err, "Error listing documents")
 	}
 
 	return nil
 }
+
+// ListBlogsPage lists a single page of blogs in the database.
+func (s *Server) ListBlogsPage(ctx context.Context, req *blogpb.ListBlogsRequest) (*blogpb.ListBlogsPageResponse, error) {
+	log.Printf("ListBlogsPage: Invoked with page size %d", req.GetPageSize())
+
+	opts, err := listOptions(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	blogs, next, err := s.listPage(ctx, opts, int(req.GetPageSize()))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("ListBlogsPage: Returning %d blogs", len(blogs))
+
+	return &blogpb.ListBlogsPageResponse{
+		Blogs:         blogs,
+		NextPageToken: next,
+	}, nil
+}
+
+// listPage lists a single page of blogs with the given options, which
+// must not have a limit, returning the token for the next page if any.
+func (s *Server) listPage(ctx context.Context, opts *database.ListOptions, pageSize int) ([]*blogpb.Blog, string, error) {
+	switch {
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	// Fetch one extra blog to find out whether there is another page
+	opts.Limit = pageSize + 1
+
+	var blogs []*blogpb.Blog
+	collect := func(blog *blogpb.Blog) error {
+		blogs = append(blogs, blog)
+		return nil
+	}
+
+	if err := s.db.ListBlogs(ctx, opts, collect); err != nil {
+		return nil, "", statusError(err, "Error listing documents")
+	}
+
+	var next string
+	if len(blogs) > pageSize {
+		blogs = blogs[:pageSize]
+		next = encodePageToken(newPageToken(blogs[pageSize-1], opts.Sort))
+	}
+
+	return blogs, next, nil
+}
+
+// SearchBlogs searches the title and content of the blogs in the database.
+func (s *Server) SearchBlogs(ctx context.Context, req *blogpb.SearchBlogsRequest) (*blogpb.SearchBlogsResponse, error) {
+	query := req.GetQ()
+	log.Printf("SearchBlogs: Invoked with query %q", query)
+
+	h := newHighlighter(query)
+	if h == nil {
+		return nil, status.Error(codes.InvalidArgument, "Search query must contain at least one term")
+	}
+
+	limit := int(req.GetPageSize())
+	switch {
+	case limit < 0:
+		return nil, status.Error(codes.InvalidArgument, "Page size must not be negative")
+	case limit == 0:
+		limit = defaultSearchSize
+	case limit > maxSearchSize:
+		limit = maxSearchSize
+	}
+
+	results, err := s.db.SearchBlogs(ctx, query, limit)
+	if err != nil {
+		return nil, statusError(err, "Error searching documents")
+	}
+
+	res := &blogpb.SearchBlogsResponse{}
+	for _, result := range results {
+		res.Results = append(res.Results, &blogpb.SearchResult{
+			Blog:     result.Blog,
+			Score:    result.Score,
+			Snippets: h.snippets(result.Blog),
+		})
+	}
+
+	log.Printf("SearchBlogs: Returning %d results", len(res.Results))
+
+	return res, nil
+}
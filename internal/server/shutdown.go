@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Shutdown ends the streaming reads in progress (ListBlogs,
+// ExportBlogs and WatchBlogs) by canceling their contexts, which
+// closes their database cursors, and fails any started later with
+// Unavailable. Such streams may otherwise run for as long as their
+// clients like, holding up a graceful stop of the gRPC server.
+// Other calls are left to finish.
+func (s *Server) Shutdown() {
+	s.stopOnce.Do(func() {
+		log.Println("Shutdown: Ending streaming reads")
+		close(s.stopping)
+	})
+}
+
+// streamContext returns the context for a streaming read, which is
+// canceled when ctx is done or the server shuts down.
+func (s *Server) streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// streamError is like statusError for the error which ended a
+// streaming read, except that a read cut off by Shutdown returns
+// Unavailable, so that clients retry it against another server.
+func (s *Server) streamError(ctx context.Context, err error, msg string) error {
+	select {
+	case <-s.stopping:
+		if ctx.Err() == nil {
+			return status.Error(codes.Unavailable, "Server is shutting down")
+		}
+	default:
+	}
+	return statusError(err, msg)
+}
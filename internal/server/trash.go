@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/validation"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListDeletedBlogs lists a single page of the blogs in the trash, in ID order.
+func (s *Server) ListDeletedBlogs(ctx context.Context, req *blogpb.ListDeletedBlogsRequest) (*blogpb.ListDeletedBlogsResponse, error) {
+	log.Printf("ListDeletedBlogs: Invoked with page size %d", req.GetPageSize())
+
+	if err := validation.ListDeletedBlogs(req); err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	opts := &database.ListOptions{
+		Sort:    database.Sort{Field: database.SortByID},
+		Deleted: true,
+	}
+
+	tok, err := decodePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if tok != nil {
+		if tok.OrderBy != sortString(opts.Sort) {
+			return nil, status.Error(codes.InvalidArgument, errInvalidPageToken.Error())
+		}
+		opts.After = &database.Cursor{ID: tok.LastID}
+	}
+
+	blogs, next, err := s.listPage(ctx, opts, int(req.GetPageSize()))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("ListDeletedBlogs: Returning %d blogs", len(blogs))
+
+	return &blogpb.ListDeletedBlogsResponse{
+		Blogs:         blogs,
+		NextPageToken: next,
+	}, nil
+}
+
+// UndeleteBlog restores a blog from the trash.
+func (s *Server) UndeleteBlog(ctx context.Context, req *blogpb.UndeleteBlogRequest) (*blogpb.UndeleteBlogResponse, error) {
+	id := req.GetId()
+	log.Printf("UndeleteBlog: Invoked with id %s", id)
+
+	if err := validation.UndeleteBlog(req); err != nil {
+		return nil, statusError(err, "Error validating request")
+	}
+
+	res, err := s.db.UndeleteBlog(ctx, id)
+	if err != nil {
+		return nil, statusError(err, "Error restoring document")
+	}
+
+	log.Println("UndeleteBlog: Blog successfully restored")
+
+	return &blogpb.UndeleteBlogResponse{
+		Blog: res,
+	}, nil
+}
+
+// SweepTrash purges the blogs which have been in the trash for
+// longer than the retention period, checking at the given interval
+// until ctx is done.
+func SweepTrash(ctx context.Context, db database.Database, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := db.PurgeDeletedBlogs(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("SweepTrash: Error purging deleted blogs: %v", err)
+		case n > 0:
+			log.Printf("SweepTrash: Purged %d blogs deleted more than %v ago", n, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
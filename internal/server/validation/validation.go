@@ -0,0 +1,360 @@
+// Package validation checks the payloads of BlogService requests
+// before they reach the database.
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// Limits on the fields of a blog, counted in characters.
+const (
+	MaxAuthorIDLength = 64
+	MaxTitleLength    = 200
+	MaxContentLength  = 100000
+)
+
+// MaxBatchSize is the most items a batch request may hold.
+const MaxBatchSize = 500
+
+// An author ID starts with a letter or number, followed by
+// letters, numbers, spaces and a few punctuation characters.
+var authorIDPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} ._'@-]*$`)
+
+// Violation describes a single invalid field of a request.
+type Violation struct {
+	// The path to the field, such as "blog.title"
+	Field string
+	// Why the field is invalid
+	Description string
+}
+
+// Error is returned when a request has one or more invalid fields.
+type Error struct {
+	Violations []Violation
+}
+
+// Error lists the violations of the request.
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Field + " " + v.Description
+	}
+	return "Invalid request: " + strings.Join(msgs, "; ")
+}
+
+// BadRequest converts the violations to the standard
+// error details for an invalid request.
+func (e *Error) BadRequest() *errdetails.BadRequest {
+	br := &errdetails.BadRequest{}
+	for _, v := range e.Violations {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+	return br
+}
+
+// validator collects the violations of a request.
+type validator struct {
+	violations []Violation
+}
+
+// add records a violation of field.
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// err returns an *Error if any violations were recorded.
+func (v *validator) err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &Error{Violations: v.violations}
+}
+
+// id checks that field holds a well-formed blog ID.
+func (v *validator) id(field, id string) {
+	if id == "" {
+		v.add(field, "is required")
+	} else if _, err := database.ParseID(id); err != nil {
+		v.add(field, "must be a 24-character hex ID")
+	}
+}
+
+// version checks an expected blog version, where zero means any.
+func (v *validator) version(field string, version int64) {
+	if version < 0 {
+		v.add(field, "must not be negative")
+	}
+}
+
+// revision checks the version of a revision, which is required.
+func (v *validator) revision(field string, version int64) {
+	if version <= 0 {
+		v.add(field, "must be positive")
+	}
+}
+
+// batchSize checks the number of items in a batch request.
+func (v *validator) batchSize(field string, n int) {
+	if n > MaxBatchSize {
+		v.add(field, "must have at most %d items", MaxBatchSize)
+	}
+}
+
+// item adds the violations of an item of a batch request,
+// validated on its own, with their fields prefixed by field.
+func (v *validator) item(field string, err error) {
+	var verr *Error
+	if !errors.As(err, &verr) {
+		return
+	}
+	for _, violation := range verr.Violations {
+		v.add(field+"."+violation.Field, "%s", violation.Description)
+	}
+}
+
+// blog checks the given fields of a blog.
+func (v *validator) blog(field string, blog *blogpb.Blog, fields []database.Field) {
+	for _, f := range fields {
+		switch f {
+		case database.FieldAuthorID:
+			v.authorID(field+".author_id", blog.GetAuthorId())
+		case database.FieldTitle:
+			v.title(field+".title", blog.GetTitle())
+		case database.FieldContent:
+			v.content(field+".content", blog.GetContent())
+		}
+	}
+}
+
+// authorID checks the author of a blog.
+func (v *validator) authorID(field, authorID string) {
+	switch {
+	case authorID == "":
+		v.add(field, "is required")
+	case utf8.RuneCountInString(authorID) > MaxAuthorIDLength:
+		v.add(field, "must be at most %d characters", MaxAuthorIDLength)
+	case !authorIDPattern.MatchString(authorID):
+		v.add(field, "must start with a letter or number and contain only letters, numbers, spaces and . _ ' @ -")
+	}
+}
+
+// title checks the title of a blog.
+func (v *validator) title(field, title string) {
+	switch {
+	case strings.TrimSpace(title) == "":
+		v.add(field, "is required")
+	case utf8.RuneCountInString(title) > MaxTitleLength:
+		v.add(field, "must be at most %d characters", MaxTitleLength)
+	}
+}
+
+// content checks the content of a blog.
+func (v *validator) content(field, content string) {
+	if utf8.RuneCountInString(content) > MaxContentLength {
+		v.add(field, "must be at most %d characters", MaxContentLength)
+	}
+}
+
+// outputOnly is the set of blog fields which are set by the
+// server. Update masks may name them, but they are ignored.
+var outputOnly = map[string]bool{
+	"id":          true,
+	"version":     true,
+	"create_time": true,
+	"update_time": true,
+	"delete_time": true,
+}
+
+// updateMask checks that the paths of an update mask name
+// updatable fields, and that at least one is named.
+func (v *validator) updateMask(field string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+
+	named := false
+	for _, path := range paths {
+		switch {
+		case outputOnly[path]:
+		case database.IsUpdatable(database.Field(path)):
+			named = true
+		default:
+			v.add(field, "cannot update %q", path)
+		}
+	}
+	if !named {
+		v.add(field, "must name at least one of author_id, title and content")
+	}
+}
+
+// CreateBlog validates a CreateBlogRequest. The ID of
+// the blog is assigned by the server, so it must be empty.
+func CreateBlog(req *blogpb.CreateBlogRequest) error {
+	v := &validator{}
+	blog := req.GetBlog()
+	if blog == nil {
+		v.add("blog", "is required")
+		return v.err()
+	}
+	if blog.GetId() != "" {
+		v.add("blog.id", "must be empty, as it is assigned by the server")
+	}
+	v.blog("blog", blog, database.UpdatableFields)
+	return v.err()
+}
+
+// BatchCreateBlogs validates a BatchCreateBlogsRequest. Unless the
+// request is all-or-nothing, its items are left for the caller to
+// check with CreateBlog, so that an invalid item fails on its own.
+func BatchCreateBlogs(req *blogpb.BatchCreateBlogsRequest) error {
+	v := &validator{}
+	v.batchSize("requests", len(req.GetRequests()))
+	if req.GetAllOrNothing() {
+		for i, item := range req.GetRequests() {
+			v.item(fmt.Sprintf("requests[%d]", i), CreateBlog(item))
+		}
+	}
+	return v.err()
+}
+
+// BatchGetBlogs validates a BatchGetBlogsRequest. Malformed
+// IDs fail on their own, like missing blogs.
+func BatchGetBlogs(req *blogpb.BatchGetBlogsRequest) error {
+	v := &validator{}
+	v.batchSize("ids", len(req.GetIds()))
+	return v.err()
+}
+
+// BatchDeleteBlogs validates a BatchDeleteBlogsRequest. Malformed
+// IDs fail on their own unless the request is all-or-nothing.
+func BatchDeleteBlogs(req *blogpb.BatchDeleteBlogsRequest) error {
+	v := &validator{}
+	v.batchSize("ids", len(req.GetIds()))
+	if req.GetAllOrNothing() {
+		for i, id := range req.GetIds() {
+			v.id(fmt.Sprintf("ids[%d]", i), id)
+		}
+	}
+	return v.err()
+}
+
+// ImportBlog validates a blog sent to ImportBlogs. Its ID is only
+// kept in upsert mode, where it must be well-formed if it is given.
+func ImportBlog(blog *blogpb.Blog, upsert bool) error {
+	v := &validator{}
+	if upsert && blog.GetId() != "" {
+		v.id("id", blog.GetId())
+	}
+	v.authorID("author_id", blog.GetAuthorId())
+	v.title("title", blog.GetTitle())
+	v.content("content", blog.GetContent())
+	return v.err()
+}
+
+// ReadBlog validates a ReadBlogRequest.
+func ReadBlog(req *blogpb.ReadBlogRequest) error {
+	v := &validator{}
+	v.id("id", req.GetId())
+	return v.err()
+}
+
+// UpdateBlog validates an UpdateBlogRequest.
+func UpdateBlog(req *blogpb.UpdateBlogRequest) error {
+	v := &validator{}
+	blog := req.GetBlog()
+	if blog == nil {
+		v.add("blog", "is required")
+		return v.err()
+	}
+	v.id("blog.id", blog.GetId())
+	v.updateMask("update_mask", req.GetUpdateMask().GetPaths())
+	v.blog("blog", blog, UpdateFields(req))
+	v.version("expected_version", req.GetExpectedVersion())
+	return v.err()
+}
+
+// UpdateFields returns the fields to update for an UpdateBlogRequest,
+// which are the updatable fields named by its update mask, or all of
+// them if the mask is empty.
+func UpdateFields(req *blogpb.UpdateBlogRequest) []database.Field {
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return database.UpdatableFields
+	}
+
+	var fields []database.Field
+	for _, f := range database.UpdatableFields {
+		for _, path := range paths {
+			if database.Field(path) == f {
+				fields = append(fields, f)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// DeleteBlog validates a DeleteBlogRequest.
+func DeleteBlog(req *blogpb.DeleteBlogRequest) error {
+	v := &validator{}
+	v.id("id", req.GetId())
+	v.version("expected_version", req.GetExpectedVersion())
+	return v.err()
+}
+
+// ListBlogRevisions validates a ListBlogRevisionsRequest.
+func ListBlogRevisions(req *blogpb.ListBlogRevisionsRequest) error {
+	v := &validator{}
+	v.id("id", req.GetId())
+	if req.GetPageSize() < 0 {
+		v.add("page_size", "must not be negative")
+	}
+	return v.err()
+}
+
+// GetBlogRevision validates a GetBlogRevisionRequest.
+func GetBlogRevision(req *blogpb.GetBlogRevisionRequest) error {
+	v := &validator{}
+	v.id("id", req.GetId())
+	v.revision("version", req.GetVersion())
+	return v.err()
+}
+
+// RestoreBlogRevision validates a RestoreBlogRevisionRequest.
+func RestoreBlogRevision(req *blogpb.RestoreBlogRevisionRequest) error {
+	v := &validator{}
+	v.id("id", req.GetId())
+	v.revision("version", req.GetVersion())
+	v.version("expected_version", req.GetExpectedVersion())
+	return v.err()
+}
+
+// ListDeletedBlogs validates a ListDeletedBlogsRequest.
+func ListDeletedBlogs(req *blogpb.ListDeletedBlogsRequest) error {
+	v := &validator{}
+	if req.GetPageSize() < 0 {
+		v.add("page_size", "must not be negative")
+	}
+	return v.err()
+}
+
+// UndeleteBlog validates an UndeleteBlogRequest.
+func UndeleteBlog(req *blogpb.UndeleteBlogRequest) error {
+	v := &validator{}
+	v.id("id", req.GetId())
+	return v.err()
+}
@@ -0,0 +1,180 @@
+package validation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"google.golang.org/genproto/protobuf/field_mask"
+)
+
+const validID = "5f00000000000000000000ab"
+
+// violated returns the fields violated by err, or nil if it is nil.
+func violated(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+	verr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Got error %v of type %T, want *Error", err, err)
+	}
+	var fields []string
+	for _, v := range verr.Violations {
+		fields = append(fields, v.Field)
+	}
+	return fields
+}
+
+func TestCreateBlog(t *testing.T) {
+	tests := []struct {
+		name string
+		blog *blogpb.Blog
+		want []string
+	}{
+		{"valid", &blogpb.Blog{AuthorId: "jane.doe@example", Title: "Title"}, nil},
+		{"missing blog", nil, []string{"blog"}},
+		{"with id", &blogpb.Blog{Id: validID, AuthorId: "author", Title: "Title"}, []string{"blog.id"}},
+		{"empty", &blogpb.Blog{}, []string{"blog.author_id", "blog.title"}},
+		{"blank title", &blogpb.Blog{AuthorId: "author", Title: " \t"}, []string{"blog.title"}},
+		{"unicode author", &blogpb.Blog{AuthorId: "Zoë O'Brien", Title: "Title"}, nil},
+		{"author starting with punctuation", &blogpb.Blog{AuthorId: "-author", Title: "Title"}, []string{"blog.author_id"}},
+		{"author with a slash", &blogpb.Blog{AuthorId: "a/b", Title: "Title"}, []string{"blog.author_id"}},
+		{"longest author", &blogpb.Blog{AuthorId: strings.Repeat("é", MaxAuthorIDLength), Title: "Title"}, nil},
+		{"long author", &blogpb.Blog{AuthorId: strings.Repeat("a", MaxAuthorIDLength+1), Title: "Title"}, []string{"blog.author_id"}},
+		// Lengths are counted in characters, not bytes
+		{"longest title", &blogpb.Blog{AuthorId: "author", Title: strings.Repeat("é", MaxTitleLength)}, nil},
+		{"long title", &blogpb.Blog{AuthorId: "author", Title: strings.Repeat("a", MaxTitleLength+1)}, []string{"blog.title"}},
+		{"longest content", &blogpb.Blog{AuthorId: "author", Title: "Title", Content: strings.Repeat("日", MaxContentLength)}, nil},
+		{"long content", &blogpb.Blog{AuthorId: "author", Title: "Title", Content: strings.Repeat("a", MaxContentLength+1)}, []string{"blog.content"}},
+	}
+
+	for _, tt := range tests {
+		got := violated(t, CreateBlog(&blogpb.CreateBlogRequest{Blog: tt.blog}))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Got violations of %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateBlog(t *testing.T) {
+	blog := &blogpb.Blog{Id: validID, AuthorId: "author", Title: "Title"}
+
+	tests := []struct {
+		name   string
+		blog   *blogpb.Blog
+		paths  []string
+		want   []string
+		fields []database.Field
+	}{
+		{"no mask", blog, nil, nil, database.UpdatableFields},
+		{"title only", &blogpb.Blog{Id: validID, Title: "Title"}, []string{"title"}, nil, []database.Field{database.FieldTitle}},
+		{"output-only paths are ignored", blog, []string{"version", "title", "update_time"}, nil, []database.Field{database.FieldTitle}},
+		{"only output-only paths", blog, []string{"id", "version"}, []string{"update_mask"}, nil},
+		{"unknown path", blog, []string{"title", "likes"}, []string{"update_mask"}, []database.Field{database.FieldTitle}},
+		{"masked field is checked", &blogpb.Blog{Id: validID}, []string{"content", "title"}, []string{"blog.title"}, []database.Field{database.FieldTitle, database.FieldContent}},
+		{"missing id", &blogpb.Blog{AuthorId: "author", Title: "Title"}, nil, []string{"blog.id"}, database.UpdatableFields},
+		{"malformed id", &blogpb.Blog{Id: "not-an-id", AuthorId: "author", Title: "Title"}, nil, []string{"blog.id"}, database.UpdatableFields},
+	}
+
+	for _, tt := range tests {
+		req := &blogpb.UpdateBlogRequest{Blog: tt.blog}
+		if tt.paths != nil {
+			req.UpdateMask = &field_mask.FieldMask{Paths: tt.paths}
+		}
+		if got := violated(t, UpdateBlog(req)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Got violations of %v, want %v", tt.name, got, tt.want)
+		}
+		if got := UpdateFields(req); !reflect.DeepEqual(got, tt.fields) {
+			t.Errorf("%s: UpdateFields returned %v, want %v", tt.name, got, tt.fields)
+		}
+	}
+}
+
+func TestBatchRequests(t *testing.T) {
+	valid := &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{AuthorId: "author", Title: "Title"}}
+	invalid := &blogpb.CreateBlogRequest{Blog: &blogpb.Blog{AuthorId: "author"}}
+	tooMany := make([]string, MaxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = validID
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{
+			"create, invalid item",
+			BatchCreateBlogs(&blogpb.BatchCreateBlogsRequest{
+				Requests: []*blogpb.CreateBlogRequest{valid, invalid},
+			}),
+			nil,
+		},
+		{
+			"create all or nothing, invalid item",
+			BatchCreateBlogs(&blogpb.BatchCreateBlogsRequest{
+				Requests:     []*blogpb.CreateBlogRequest{valid, invalid},
+				AllOrNothing: true,
+			}),
+			[]string{"requests[1].blog.title"},
+		},
+		{
+			"get, malformed id",
+			BatchGetBlogs(&blogpb.BatchGetBlogsRequest{Ids: []string{"not-an-id"}}),
+			nil,
+		},
+		{
+			"get, too many",
+			BatchGetBlogs(&blogpb.BatchGetBlogsRequest{Ids: tooMany}),
+			[]string{"ids"},
+		},
+		{
+			"delete, malformed id",
+			BatchDeleteBlogs(&blogpb.BatchDeleteBlogsRequest{Ids: []string{validID, "not-an-id"}}),
+			nil,
+		},
+		{
+			"delete all or nothing, malformed id",
+			BatchDeleteBlogs(&blogpb.BatchDeleteBlogsRequest{
+				Ids:          []string{validID, "not-an-id"},
+				AllOrNothing: true,
+			}),
+			[]string{"ids[1]"},
+		},
+		{
+			"delete, too many",
+			BatchDeleteBlogs(&blogpb.BatchDeleteBlogsRequest{Ids: tooMany}),
+			[]string{"ids"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := violated(t, tt.err); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Got violations of %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImportBlog(t *testing.T) {
+	tests := []struct {
+		name   string
+		blog   *blogpb.Blog
+		upsert bool
+		want   []string
+	}{
+		{"id ignored when creating", &blogpb.Blog{Id: "not-an-id", AuthorId: "author", Title: "Title"}, false, nil},
+		{"valid id when upserting", &blogpb.Blog{Id: validID, AuthorId: "author", Title: "Title"}, true, nil},
+		{"malformed id when upserting", &blogpb.Blog{Id: "not-an-id", AuthorId: "author", Title: "Title"}, true, []string{"id"}},
+		{"no id when upserting", &blogpb.Blog{AuthorId: "author", Title: "Title"}, true, nil},
+		{"empty", &blogpb.Blog{}, false, []string{"author_id", "title"}},
+	}
+
+	for _, tt := range tests {
+		if got := violated(t, ImportBlog(tt.blog, tt.upsert)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Got violations of %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+
+	"github.com/dnys1/grpc-mongo/internal/etag"
+	"github.com/dnys1/grpc-mongo/internal/server/validation"
+	"google.golang.org/grpc/metadata"
+)
+
+// expectedVersion returns the blog version which a write expects.
+// This is the version given in the request or, if unset, the one
+// in the If-Match header forwarded by the gateway. The header is
+// read from the metadata of any caller, so gRPC clients may send
+// an ETag there too. Zero means that any version is accepted.
+func expectedVersion(ctx context.Context, version int64) (int64, error) {
+	if version != 0 {
+		return version, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(etag.Header)
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	version, err := etag.Parse(values[0])
+	if err != nil {
+		return 0, &validation.Error{Violations: []validation.Violation{{
+			Field:       "If-Match",
+			Description: `must be "*" or the ETag of a blog, e.g. "3"`,
+		}}}
+	}
+	return version, nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"log"
+
+	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+)
+
+// The event type of each kind of change
+var eventTypes = map[database.ChangeType]blogpb.BlogEvent_EventType{
+	database.Created: blogpb.BlogEvent_CREATED,
+	database.Updated: blogpb.BlogEvent_UPDATED,
+	database.Deleted: blogpb.BlogEvent_DELETED,
+}
+
+// WatchBlogs streams the changes to blogs until the client
+// goes away or the server shuts down.
+func (s *Server) WatchBlogs(req *blogpb.WatchBlogsRequest, stream blogpb.BlogService_WatchBlogsServer) error {
+	log.Printf("WatchBlogs: Invoked with author %q (resuming %t)", req.GetAuthorId(), req.GetResumeToken() != "")
+
+	opts := &database.WatchOptions{
+		AuthorID:    req.GetAuthorId(),
+		ResumeToken: req.GetResumeToken(),
+	}
+
+	sent := 0
+	send := func(change *database.Change) error {
+		sent++
+		return stream.Send(&blogpb.BlogEvent{
+			Type:        eventTypes[change.Type],
+			Blog:        change.Blog,
+			ResumeToken: change.ResumeToken,
+		})
+	}
+
+	ctx, cancel := s.streamContext(stream.Context())
+	defer cancel()
+
+	err := s.db.WatchBlogs(ctx, opts, send)
+	log.Printf("WatchBlogs: Sent %d events", sent)
+	if err != nil {
+		return s.streamError(stream.Context(), err, "Error watching documents")
+	}
+
+	return nil
+}
@@ -2,18 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/dnys1/grpc-mongo/internal/gateway"
 	"github.com/dnys1/grpc-mongo/internal/model/blogpb"
 	"github.com/dnys1/grpc-mongo/internal/server"
-	db "github.com/dnys1/grpc-mongo/internal/server/database/mongo"
+	"github.com/dnys1/grpc-mongo/internal/server/database"
+	"github.com/dnys1/grpc-mongo/internal/server/database/cache"
+	"github.com/dnys1/grpc-mongo/internal/server/database/memory"
+	mongodb "github.com/dnys1/grpc-mongo/internal/server/database/mongo"
+	"github.com/dnys1/grpc-mongo/internal/server/database/resilience"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -21,10 +31,84 @@ var (
 	// Command-line options
 	grpcHost = flag.String("grpc-host", "localhost", "gRPC server endpoint host")
 	grpcPort = flag.Int("grpc-port", 50051, "gRPC server endpoint port")
+	dbDriver = flag.String("db-driver", "mongo", "Database driver (mongo or memory)")
 	dbHost   = flag.String("db-host", "localhost", "Database host")
 	dbPort   = flag.Int("db-port", 27017, "Database port")
+
+	dbURI         = flag.String("db-uri", "", "MongoDB connection URI (overrides --db-host and --db-port)")
+	dbUsername    = flag.String("db-username", "", "MongoDB username")
+	dbPassword    = flag.String("db-password", "", "MongoDB password (defaults to $DB_PASSWORD)")
+	dbAuthSource  = flag.String("db-auth-source", "", "MongoDB authentication database")
+	dbTLS         = flag.Bool("db-tls", false, "Connect to MongoDB over TLS")
+	dbTLSCAFile   = flag.String("db-tls-ca-file", "", "PEM file with CA certificates for verifying MongoDB")
+	dbTLSCertFile = flag.String("db-tls-cert-file", "", "PEM file with the client certificate and key for MongoDB")
+	dbReplicaSet  = flag.String("db-replica-set", "", "MongoDB replica set name")
+	dbName        = flag.String("db-name", "mydb", "MongoDB database name")
+	dbCollection  = flag.String("db-collection", "blog", "MongoDB collection name for blogs")
+
+	ensureIndexes      = flag.Bool("ensure-indexes", true, "Create missing database indexes on startup")
+	dropUnknownIndexes = flag.Bool("drop-unknown-indexes", false, "Drop undeclared database indexes on startup")
+	migrateOnStart     = flag.Bool("migrate", false, "Apply pending database migrations on startup")
+
+	trashRetention     = flag.Duration("trash-retention", 30*24*time.Hour, "How long deleted blogs are kept in the trash before they are purged (0 keeps them forever)")
+	trashSweepInterval = flag.Duration("trash-sweep-interval", time.Hour, "How often the trash is checked for blogs to purge")
+
+	dbTimeout          = flag.Duration("db-timeout", 10*time.Second, "How long a single database call may take (0 for no limit)")
+	dbRetries          = flag.Int("db-retries", 2, "How many times a database read is retried while the database is unavailable")
+	dbRetryBackoff     = flag.Duration("db-retry-backoff", 100*time.Millisecond, "The delay before the first retry of a database read, doubling for each further retry")
+	dbRetryMaxBackoff  = flag.Duration("db-retry-max-backoff", 2*time.Second, "The longest delay between retries of a database read")
+	dbBreakerThreshold = flag.Int("db-breaker-threshold", 5, "How many database calls in a row must fail to stop calling the database for a while (0 disables the circuit breaker)")
+	dbBreakerCooldown  = flag.Duration("db-breaker-cooldown", 10*time.Second, "How long database calls are rejected once the circuit breaker opens")
+
+	cacheSize = flag.Int("cache-size", 0, "How many blogs to keep in the read cache (0 disables the cache)")
+	cacheTTL  = flag.Duration("cache-ttl", time.Minute, "How long a blog is kept in the read cache")
+
+	healthCheckInterval = flag.Duration("health-check-interval", 10*time.Second, "How often the database is pinged to report the health of the server")
+
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "How long requests in progress are given to finish on shutdown before they are cut off, and then how long the database is given to disconnect")
+
+	importBatchSize = flag.Int("import-batch-size", server.DefaultImportBatchSize, "How many imported blogs are written to the database at a time")
 )
 
+// newMongoDatabase creates a MongoDB database from the command-line flags.
+func newMongoDatabase() (*mongodb.MongoDatabase, error) {
+	password := *dbPassword
+	if password == "" {
+		password = os.Getenv("DB_PASSWORD")
+	}
+
+	return mongodb.New(&mongodb.MongoDatabaseOptions{
+		URI:                   *dbURI,
+		Host:                  *dbHost,
+		Port:                  *dbPort,
+		Username:              *dbUsername,
+		Password:              password,
+		AuthSource:            *dbAuthSource,
+		TLS:                   *dbTLS,
+		TLSCAFile:             *dbTLSCAFile,
+		TLSCertificateKeyFile: *dbTLSCertFile,
+		ReplicaSet:            *dbReplicaSet,
+		Database:              *dbName,
+		Collection:            *dbCollection,
+		Indexes: mongodb.IndexOptions{
+			Ensure:      *ensureIndexes,
+			DropUnknown: *dropUnknownIndexes,
+		},
+	})
+}
+
+// newDatabase creates the database selected by the --db-driver flag.
+func newDatabase() (database.Database, error) {
+	switch *dbDriver {
+	case "mongo":
+		return newMongoDatabase()
+	case "memory":
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("Unknown database driver %q", *dbDriver)
+	}
+}
+
 func main() {
 	// Parse command-line flags
 	flag.Parse()
@@ -34,65 +118,188 @@ func main() {
 
 	ctx := context.Background()
 
-	// Create MongoDB client
-	db, err := db.New(&db.MongoDatabaseOptions{
-		Host: *dbHost,
-		Port: *dbPort,
-	})
+	// Run the migrate subcommand instead of the server
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(ctx, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := run(ctx); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server shut down successfully.")
+}
+
+// run connects to the database and serves until a signal is received
+// or a server fails. Once the database is connected, every return
+// disconnects from it, and once the servers have started, every
+// return shuts them down gracefully first.
+func run(ctx context.Context) (err error) {
+	// Create database client
+	db, err := newDatabase()
 	if err != nil {
-		log.Fatalf("Error creating database: %v", err)
+		return fmt.Errorf("Error creating database: %v", err)
 	}
 	log.Printf("Connecting to database at %s ...", db.Endpoint())
 	if err := db.Connect(ctx); err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer disconnect(db, &err)
 
-	defer func() {
-		log.Println("Closing database connection...")
-		if err = db.Disconnect(ctx); err != nil {
-			log.Fatal(err)
+	if *migrateOnStart {
+		mdb, ok := db.(*mongodb.MongoDatabase)
+		if !ok {
+			return fmt.Errorf("Migrations are not supported by the %s driver", *dbDriver)
+		}
+		if err := migrateUp(ctx, mdb, 0); err != nil {
+			return err
 		}
-		log.Println("MongoDB connection closed successfully.")
+	}
+	if mdb, ok := db.(*mongodb.MongoDatabase); ok {
+		if err := mdb.CheckTimes(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Limit, retry and stop database calls while the database is struggling
+	resilient := resilience.New(db, resilience.Options{
+		Timeout:          *dbTimeout,
+		MaxRetries:       *dbRetries,
+		Backoff:          *dbRetryBackoff,
+		MaxBackoff:       *dbRetryMaxBackoff,
+		FailureThreshold: *dbBreakerThreshold,
+		Cooldown:         *dbBreakerCooldown,
+	})
+	expvar.Publish("blog_database", expvar.Func(func() interface{} {
+		return resilient.Stats()
+	}))
+	db = resilient
+
+	// Serve reads of recently read blogs from memory
+	if *cacheSize > 0 {
+		if *cacheTTL <= 0 {
+			return fmt.Errorf("Invalid --cache-ttl %v", *cacheTTL)
+		}
+		cached := cache.New(db, cache.Options{Size: *cacheSize, TTL: *cacheTTL})
+		expvar.Publish("blog_cache", expvar.Func(func() interface{} {
+			return cached.Stats()
+		}))
+		db = cached
+	}
+
+	// Background tasks run until the servers have shut down,
+	// and are stopped before the database is disconnected
+	backgroundCtx, stopBackground := context.WithCancel(ctx)
+	var background sync.WaitGroup
+	defer func() {
+		stopBackground()
+		background.Wait()
 	}()
 
+	// Purge blogs which have been in the trash for too long
+	if *trashRetention > 0 {
+		if *trashSweepInterval <= 0 {
+			return fmt.Errorf("Invalid --trash-sweep-interval %v", *trashSweepInterval)
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			server.SweepTrash(backgroundCtx, db, *trashRetention, *trashSweepInterval)
+		}()
+	}
+
 	// Connect to gRPC service
 	log.Printf("Starting gRPC server on port %d ...", *grpcPort)
 	grpcEndpoint := fmt.Sprintf("%s:%d", *grpcHost, *grpcPort)
 	lis, err := net.Listen("tcp", grpcEndpoint)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		return fmt.Errorf("Failed to listen: %v", err)
 	}
 
 	grpcServer := grpc.NewServer()
-	blogpb.RegisterBlogServiceServer(grpcServer, server.NewServer(db))
+	blogServer := server.NewServer(db, &server.Options{
+		ImportBatchSize: *importBatchSize,
+	})
+	blogpb.RegisterBlogServiceServer(grpcServer, blogServer)
+
+	// Report the health of the server, following the database
+	if *healthCheckInterval <= 0 {
+		lis.Close()
+		return fmt.Errorf("Invalid --health-check-interval %v", *healthCheckInterval)
+	}
+	healthServer := server.NewHealthServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		server.CheckHealth(backgroundCtx, db, healthServer, *healthCheckInterval)
+	}()
 
 	// Register reflection service on gRPC server
 	reflection.Register(grpcServer)
 
-	// Start the gRPC server
+	// Create the gateway reverse proxy, which connects to the gRPC server lazily
+	gw, err := gateway.New(ctx, grpcEndpoint)
+	if err != nil {
+		lis.Close()
+		return err
+	}
+
+	// Start the gRPC server and the gateway. From here on, every
+	// failure goes through the graceful shutdown below.
+	errc := make(chan error, 2)
 	go func() {
 		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatalf("Failed to serve gRPC server: %v", err)
+			errc <- fmt.Errorf("Failed to serve gRPC server: %v", err)
 		}
 	}()
-
-	// Start the gateway reverse proxy
 	go func() {
-		if err := gateway.Run(grpcEndpoint); err != nil {
-			log.Fatalf("Failed to serve gateway server: %v", err)
+		if err := gw.Serve(); err != nil {
+			errc <- fmt.Errorf("Failed to serve gateway server: %v", err)
 		}
 	}()
 
-	// Wait for Control-C to exit
+	// Wait for Control-C, or the SIGTERM sent by Docker, to exit
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 
-	// Block until a signal is received
-	<-ch
+	// Block until a signal is received or a server fails
+	var serveErr error
+	select {
+	case sig := <-ch:
+		log.Printf("Received %v, shutting down server...", sig)
+	case serveErr = <-errc:
+		log.Printf("%v; shutting down server...", serveErr)
+	}
 
-	// Shut down server
-	log.Println("Shutting down server...")
-	grpcServer.Stop()
-	lis.Close()
-	log.Println("Server shut down successfully.")
+	// Shut down the servers; the background tasks and the
+	// database connection follow as run returns
+	drained := drain(ch, blogServer, healthServer, grpcServer, gw)
+	switch {
+	case serveErr != nil:
+		return fmt.Errorf("Server shut down with errors: %v", serveErr)
+	case !drained:
+		return errors.New("Server shut down with errors: requests in progress were cut off")
+	}
+	return nil
+}
+
+// disconnect closes the connection to db, with the shutdown timeout.
+// If it fails, the error is stored in *errp unless there already is
+// one, in which case it is only logged.
+func disconnect(db database.Database, errp *error) {
+	log.Println("Closing database connection...")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	switch err := db.Disconnect(ctx); {
+	case err == nil:
+		log.Println("Database connection closed successfully.")
+	case *errp == nil:
+		*errp = fmt.Errorf("Error closing database connection: %v", err)
+	default:
+		log.Printf("Error closing database connection: %v", err)
+	}
 }
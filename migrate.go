@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	mongodb "github.com/dnys1/grpc-mongo/internal/server/database/mongo"
+	"github.com/dnys1/grpc-mongo/internal/server/database/mongo/migrations"
+)
+
+// runMigrate runs the migrate subcommand:
+//
+//	server [flags] migrate up [version]
+//	server [flags] migrate down <version>
+//	server [flags] migrate status
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: migrate up [version] | down <version> | status")
+	}
+
+	cmd, args := args[0], args[1:]
+	version := 0
+	switch {
+	case cmd == "status" && len(args) > 0:
+		return fmt.Errorf("Usage: migrate status")
+	case len(args) > 1:
+		return fmt.Errorf("Too many arguments to migrate %s", cmd)
+	case len(args) == 1:
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v < 0 {
+			return fmt.Errorf("Invalid migration version %q", args[0])
+		}
+		version = v
+	case cmd == "down":
+		return fmt.Errorf("Usage: migrate down <version>")
+	}
+
+	db, err := newMongoDatabase()
+	if err != nil {
+		return fmt.Errorf("Error creating database: %v", err)
+	}
+	log.Printf("Connecting to database at %s ...", db.Endpoint())
+	if err := db.Connect(ctx); err != nil {
+		return err
+	}
+	defer db.Disconnect(ctx)
+
+	switch cmd {
+	case "up":
+		return migrateUp(ctx, db, version)
+	case "down":
+		return migrateDown(ctx, db, version)
+	case "status":
+		return migrateStatus(ctx, db)
+	default:
+		return fmt.Errorf("Unknown migrate command %q", cmd)
+	}
+}
+
+// migrateUp applies the pending migrations up to and
+// including version, or all of them if version is zero.
+func migrateUp(ctx context.Context, db *mongodb.MongoDatabase, version int) error {
+	m, err := migrations.New(db.Collection(), migrations.Blog)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Applying database migrations...")
+	applied, err := m.Up(ctx, version)
+	for _, v := range applied {
+		log.Printf("Applied migration %d", v)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Database migrations applied successfully (%d new).", len(applied))
+
+	return nil
+}
+
+// migrateDown reverts the applied migrations above version.
+func migrateDown(ctx context.Context, db *mongodb.MongoDatabase, version int) error {
+	m, err := migrations.New(db.Collection(), migrations.Blog)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reverting database migrations above version %d...", version)
+	reverted, err := m.Down(ctx, version)
+	for _, v := range reverted {
+		log.Printf("Reverted migration %d", v)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Database migrations reverted successfully (%d reverted).", len(reverted))
+
+	return nil
+}
+
+// migrateStatus logs the state of every known migration.
+func migrateStatus(ctx context.Context, db *mongodb.MongoDatabase) error {
+	m, err := migrations.New(db.Collection(), migrations.Blog)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(statuses) == 0 {
+		log.Println("No migrations are defined.")
+	}
+	for _, st := range statuses {
+		state := "pending"
+		if st.AppliedAt != nil {
+			state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		log.Printf("%4d  %-28s  %s", st.Migration.Version, state, st.Migration.Description)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/dnys1/grpc-mongo/internal/gateway"
+	"github.com/dnys1/grpc-mongo/internal/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// drain shuts down the gateway and gRPC servers, giving the requests
+// in progress until --shutdown-timeout, or until another signal is
+// received on ch, to finish before cutting them off. It reports
+// whether every request finished.
+func drain(ch <-chan os.Signal, blogServer *server.Server, healthServer *health.Server, grpcServer *grpc.Server, gw *gateway.Gateway) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case sig := <-ch:
+			log.Printf("Received %v again, cutting off requests in progress...", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Report NOT_SERVING to health checks, and end the streams
+	// which would otherwise hold up the graceful stop
+	healthServer.Shutdown()
+	blogServer.Shutdown()
+
+	ok := true
+
+	// The gateway goes first, as it forwards its requests to the gRPC server
+	log.Println("Shutting down gateway server...")
+	if err := gw.Shutdown(ctx); err != nil {
+		log.Printf("Gateway requests were cut off: %v", err)
+		ok = false
+	}
+
+	log.Println("Shutting down gRPC server...")
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("gRPC calls were cut off: %v", ctx.Err())
+		grpcServer.Stop()
+		<-stopped
+		ok = false
+	}
+
+	return ok
+}